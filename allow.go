@@ -0,0 +1,350 @@
+package zfs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Delegated permissions passed to Allow and Unallow
+type Permission struct {
+	Users    []string
+	Groups   []string
+	Everyone bool
+
+	// Permissions apply only to dataset itself (-l) or only to its
+	// descendents (-d), both if none is set
+	Local      bool
+	Descendent bool
+
+	// Permission names like create, mount, snapshot or properties
+	Perms []string
+
+	// Permission set names, with or without leading @
+	Sets []string
+}
+
+func (p Permission) perms() string {
+	perms := append([]string{}, p.Perms...)
+	for _, set := range p.Sets {
+		perms = append(perms, "@"+strings.TrimPrefix(set, "@"))
+	}
+
+	return strings.Join(perms, ",")
+}
+
+// Returns argument lists for every kind of grantee
+func (p Permission) args(command string) [][]string {
+	base := []string{command}
+	if p.Local {
+		base = append(base, "-l")
+	}
+	if p.Descendent {
+		base = append(base, "-d")
+	}
+
+	grantees := [][]string{}
+	if len(p.Users) > 0 {
+		grantees = append(grantees, []string{"-u", strings.Join(p.Users, ",")})
+	}
+	if len(p.Groups) > 0 {
+		grantees = append(grantees, []string{"-g", strings.Join(p.Groups, ",")})
+	}
+	if p.Everyone {
+		grantees = append(grantees, []string{"-e"})
+	}
+
+	perms := p.perms()
+
+	args := [][]string{}
+	for _, grantee := range grantees {
+		cmd := append(append([]string{}, base...), grantee...)
+		if perms != "" {
+			cmd = append(cmd, perms)
+		}
+		args = append(args, cmd)
+	}
+
+	return args
+}
+
+// Delegates permissions on filesystem
+func (f Fs) Allow(p Permission) error {
+	if p.perms() == "" {
+		return errors.New("no permissions to allow")
+	}
+
+	return f.runPermission("allow", p)
+}
+
+// Removes delegated permissions, all permissions of grantees are removed
+// if no Perms and Sets are given
+func (f Fs) Unallow(p Permission) error {
+	return f.runPermission("unallow", p)
+}
+
+func (f Fs) runPermission(command string, p Permission) error {
+	args := p.args(command)
+	if len(args) == 0 {
+		return errors.New("no users, groups or everyone given")
+	}
+
+	for _, cmd := range args {
+		c := f.runner.Command("zfs", append(cmd, f.Path)...)
+
+		if _, stderr, err := c.Output(); err != nil {
+			return parseError(err, stderr)
+		}
+	}
+
+	return nil
+}
+
+// Defines or extends permission set
+func (f Fs) AllowSet(set string, perms ...string) error {
+	c := f.runner.Command(
+		"zfs", "allow", "-s", "@"+strings.TrimPrefix(set, "@"),
+		strings.Join(perms, ","), f.Path,
+	)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// Removes permissions from set, whole set is removed if no perms given
+func (f Fs) UnallowSet(set string, perms ...string) error {
+	args := []string{"unallow", "-s", "@" + strings.TrimPrefix(set, "@")}
+	if len(perms) > 0 {
+		args = append(args, strings.Join(perms, ","))
+	}
+
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// Sets permissions granted to creator of descendent datasets
+func (f Fs) AllowCreate(perms ...string) error {
+	c := f.runner.Command(
+		"zfs", "allow", "-c", strings.Join(perms, ","), f.Path,
+	)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+type PermissionEntry struct {
+	// user, group or everyone
+	Kind  string
+	Name  string
+	Perms []string
+}
+
+func (e PermissionEntry) matches(user string, groups []string) bool {
+	switch e.Kind {
+	case "everyone":
+		return true
+	case "user":
+		return e.Name == user
+	case "group":
+		for _, group := range groups {
+			if e.Name == group {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Permissions defined on one dataset
+type DatasetPermissions struct {
+	Path            string
+	Sets            map[string][]string
+	Create          []string
+	Local           []PermissionEntry
+	Descendent      []PermissionEntry
+	LocalDescendent []PermissionEntry
+}
+
+// Returns permissions on filesystem and its ancestors, as printed by
+// zfs allow, nearest dataset first
+func (f Fs) Permissions() ([]DatasetPermissions, error) {
+	c := f.runner.Command("zfs", "allow", f.Path)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return nil, parseError(err, stderr)
+	}
+
+	return parsePermissions(string(stdout))
+}
+
+func parsePermissions(output string) ([]DatasetPermissions, error) {
+	result := []DatasetPermissions{}
+
+	var current *DatasetPermissions
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "---- Permissions on ") {
+			path := strings.TrimPrefix(line, "---- Permissions on ")
+			path = strings.TrimSpace(strings.TrimRight(path, "-"))
+			result = append(result, DatasetPermissions{
+				Path: path,
+				Sets: map[string][]string{},
+			})
+			current = &result[len(result)-1]
+			section = ""
+			continue
+		}
+
+		if current == nil {
+			return result, errors.New("unexpected zfs allow output: " + line)
+		}
+
+		if !strings.HasPrefix(line, "\t") && strings.HasSuffix(line, ":") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch section {
+		case "Permission sets":
+			if len(fields) == 2 {
+				current.Sets[fields[0]] = strings.Split(fields[1], ",")
+			}
+		case "Create time permissions":
+			if len(fields) == 1 {
+				current.Create = strings.Split(fields[0], ",")
+			}
+		case "Local permissions":
+			current.Local = appendPermissionEntry(current.Local, fields)
+		case "Descendent permissions":
+			current.Descendent = appendPermissionEntry(current.Descendent, fields)
+		case "Local+Descendent permissions":
+			current.LocalDescendent = appendPermissionEntry(
+				current.LocalDescendent, fields,
+			)
+		default:
+			return result, errors.New("unexpected zfs allow output: " + line)
+		}
+	}
+
+	return result, nil
+}
+
+func appendPermissionEntry(
+	entries []PermissionEntry, fields []string,
+) []PermissionEntry {
+	switch {
+	case len(fields) == 2 && fields[0] == "everyone":
+		return append(entries, PermissionEntry{
+			Kind:  "everyone",
+			Perms: strings.Split(fields[1], ","),
+		})
+	case len(fields) == 3:
+		return append(entries, PermissionEntry{
+			Kind:  fields[0],
+			Name:  fields[1],
+			Perms: strings.Split(fields[2], ","),
+		})
+	default:
+		return entries
+	}
+}
+
+// Checks whether user the runner is working as can perform operation on
+// filesystem, either being root or having delegated permission. User is
+// determined through runner escalation, so with sudo it is root.
+func (f Fs) CanPerform(perm string) (bool, error) {
+	user, groups, err := f.runner.currentUser()
+	if err != nil {
+		return false, err
+	}
+
+	if user == "root" {
+		return true, nil
+	}
+
+	permissions, err := f.Permissions()
+	if err != nil {
+		return false, err
+	}
+
+	return hasPermission(permissions, f.Path, user, groups, perm), nil
+}
+
+func hasPermission(
+	permissions []DatasetPermissions,
+	path, user string, groups []string, perm string,
+) bool {
+	for _, dataset := range permissions {
+		entries := append([]PermissionEntry{}, dataset.LocalDescendent...)
+		if dataset.Path == path {
+			entries = append(entries, dataset.Local...)
+		} else {
+			entries = append(entries, dataset.Descendent...)
+		}
+
+		for _, entry := range entries {
+			if !entry.matches(user, groups) {
+				continue
+			}
+
+			for _, granted := range entry.Perms {
+				if granted == perm {
+					return true
+				}
+
+				if strings.HasPrefix(granted, "@") &&
+					setContains(permissions, granted, perm) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Looks for set definition starting from nearest dataset
+func setContains(
+	permissions []DatasetPermissions, set string, perm string,
+) bool {
+	for _, dataset := range permissions {
+		perms, ok := dataset.Sets[set]
+		if !ok {
+			continue
+		}
+
+		for _, p := range perms {
+			if p == perm {
+				return true
+			}
+		}
+		return false
+	}
+
+	return false
+}
+
+// Returns user name and groups zfs commands are run as, with privilege
+// escalation applied
+func (z *ZfsRunner) currentUser() (string, []string, error) {
+	stdout, stderr, err := z.Command("id", "-un").Output()
+	if err != nil {
+		return "", nil, parseError(err, stderr)
+	}
+	user := strings.TrimSpace(string(stdout))
+
+	stdout, stderr, err = z.Command("id", "-Gn").Output()
+	if err != nil {
+		return "", nil, parseError(err, stderr)
+	}
+
+	return user, strings.Fields(string(stdout)), nil
+}
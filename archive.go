@@ -0,0 +1,336 @@
+package zfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DefaultChunkSize = 1 << 30
+
+// Backend used to store archived snapshot streams
+type ArchiveStorage interface {
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+}
+
+// ArchiveStorage keeping archives as files in local directory
+type DirStorage struct {
+	Dir string
+}
+
+func NewDirStorage(dir string) (DirStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return DirStorage{}, err
+	}
+
+	return DirStorage{dir}, nil
+}
+
+func (d DirStorage) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(d.Dir, name))
+}
+
+func (d DirStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.Dir, name))
+}
+
+func (d DirStorage) Remove(name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+type ArchiveChunk struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Describes archived stream, stored next to chunks
+type ArchiveManifest struct {
+	Snapshot string      `json:"snapshot"`
+	GUID     string      `json:"guid"`
+	Base     string      `json:"base,omitempty"`
+	BaseGUID string      `json:"base_guid,omitempty"`
+	Options  SendOptions `json:"options"`
+
+	Size      int64          `json:"size"`
+	ChunkSize int64          `json:"chunk_size"`
+	Chunks    []ArchiveChunk `json:"chunks"`
+	Created   time.Time      `json:"created"`
+}
+
+func archiveManifestName(name string) string {
+	return name + ".manifest.json"
+}
+
+func archiveChunkName(name string, index int) string {
+	return fmt.Sprintf("%s.%06d", name, index)
+}
+
+// Writes snapshot stream into storage as chunks of chunkSize bytes, named
+// after given archive name, and stores manifest describing them. If base is
+// not nil incremental stream is archived. In dry-run mode nothing is
+// written to storage.
+func (s Snapshot) Archive(
+	base *Snapshot, opts SendOptions,
+	storage ArchiveStorage, name string, chunkSize int64,
+) (ArchiveManifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	manifest := ArchiveManifest{
+		Snapshot:  s.Path,
+		Options:   opts,
+		ChunkSize: chunkSize,
+		Created:   time.Now(),
+	}
+
+	guid, err := s.GetProperty("guid")
+	if err != nil {
+		return manifest, err
+	}
+	manifest.GUID = guid
+
+	if base != nil {
+		baseGuid, err := base.GetProperty("guid")
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Base = base.Path
+		manifest.BaseGUID = baseGuid
+	}
+
+	// wrappers are not recorded in manifest, RestoreArchive receives
+	// chunks as is
+	s.transfer.Wrappers = nil
+
+	// send is recorded in plan, storage is left untouched
+	if s.runner.IsDryRun() {
+		return manifest, s.SendStreamWithOptions(base, io.Discard, opts)
+	}
+
+	w := &chunkWriter{storage: storage, name: name, chunkSize: chunkSize}
+
+	err = s.SendStreamWithOptions(base, w, opts)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		w.remove()
+		return manifest, err
+	}
+
+	manifest.Chunks = w.chunks
+	manifest.Size = w.total
+
+	if err := writeArchiveManifest(storage, name, manifest); err != nil {
+		w.remove()
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+func writeArchiveManifest(
+	storage ArchiveStorage, name string, manifest ArchiveManifest,
+) error {
+	f, err := storage.Create(archiveManifestName(name))
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		f.Close()
+		return errors.New("error writing manifest: " + err.Error())
+	}
+
+	return f.Close()
+}
+
+func ReadArchiveManifest(
+	storage ArchiveStorage, name string,
+) (ArchiveManifest, error) {
+	manifest := ArchiveManifest{}
+
+	f, err := storage.Open(archiveManifestName(name))
+	if err != nil {
+		return manifest, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return manifest, errors.New("error reading manifest: " + err.Error())
+	}
+
+	return manifest, nil
+}
+
+// Checks that all archive chunks are present and match their checksums
+func VerifyArchive(
+	storage ArchiveStorage, name string,
+) (ArchiveManifest, error) {
+	manifest, err := ReadArchiveManifest(storage, name)
+	if err != nil {
+		return manifest, err
+	}
+
+	return manifest, readArchive(storage, manifest, io.Discard)
+}
+
+// Verifies archive and receives stored stream into given entry
+func RestoreArchive(storage ArchiveStorage, name string, to ZfsEntry) error {
+	manifest, err := VerifyArchive(storage, name)
+	if err != nil {
+		return err
+	}
+
+	rc, stdinPipe, err := to.Receive()
+	if err != nil {
+		return err
+	}
+
+	err = readArchive(storage, manifest, stdinPipe)
+
+	return finishReceive(rc, stdinPipe, err)
+}
+
+// Writes archive chunks to dest in order, checking each chunk size and
+// checksum
+func readArchive(
+	storage ArchiveStorage, manifest ArchiveManifest, dest io.Writer,
+) error {
+	for _, chunk := range manifest.Chunks {
+		f, err := storage.Open(chunk.Name)
+		if err != nil {
+			return err
+		}
+
+		checksum := sha256.New()
+		n, err := io.Copy(io.MultiWriter(dest, checksum), f)
+		f.Close()
+		if err != nil {
+			return errors.New(
+				"error reading chunk '" + chunk.Name + "': " + err.Error(),
+			)
+		}
+
+		if n != chunk.Size {
+			return errors.New(fmt.Sprintf(
+				"chunk '%s' size mismatch: %d, want %d", chunk.Name, n, chunk.Size,
+			))
+		}
+
+		if sum := hex.EncodeToString(checksum.Sum(nil)); sum != chunk.SHA256 {
+			return errors.New(fmt.Sprintf(
+				"chunk '%s' checksum mismatch: %s, want %s",
+				chunk.Name, sum, chunk.SHA256,
+			))
+		}
+	}
+
+	return nil
+}
+
+// Splits written data into chunks of chunkSize bytes in storage
+type chunkWriter struct {
+	storage   ArchiveStorage
+	name      string
+	chunkSize int64
+
+	current io.WriteCloser
+	hash    hash.Hash
+	written int64
+	total   int64
+	chunks  []ArchiveChunk
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.current == nil {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+
+		part := p
+		if left := w.chunkSize - w.written; int64(len(part)) > left {
+			part = part[:left]
+		}
+
+		n, err := w.current.Write(part)
+		w.hash.Write(part[:n])
+		w.written += int64(n)
+		w.total += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		if w.written == w.chunkSize {
+			if err := w.finish(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *chunkWriter) next() error {
+	name := archiveChunkName(w.name, len(w.chunks))
+	f, err := w.storage.Create(name)
+	if err != nil {
+		return err
+	}
+
+	w.current = f
+	w.hash = sha256.New()
+	w.written = 0
+	w.chunks = append(w.chunks, ArchiveChunk{Name: name})
+
+	return nil
+}
+
+func (w *chunkWriter) finish() error {
+	chunk := &w.chunks[len(w.chunks)-1]
+	chunk.Size = w.written
+	chunk.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+
+	err := w.current.Close()
+	w.current = nil
+
+	return err
+}
+
+func (w *chunkWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+
+	return w.finish()
+}
+
+// Removes all chunks written so far
+func (w *chunkWriter) remove() {
+	if w.current != nil {
+		w.current.Close()
+		w.current = nil
+	}
+
+	for _, chunk := range w.chunks {
+		w.storage.Remove(chunk.Name)
+	}
+}
@@ -0,0 +1,32 @@
+package zfs
+
+type Bookmark struct {
+	zfsEntryBase
+	Fs   Fs
+	Name string
+}
+
+// See Zfs.NewBookmark
+func NewBookmark(bookmarkPath string) (Bookmark, error) {
+	return std().NewBookmark(bookmarkPath)
+}
+
+// Return Bookmark wrapper without actualy creation, bookmark path is
+// checked with ParseName
+func (z Zfs) NewBookmark(bookmarkPath string) (Bookmark, error) {
+	name, err := ParseName(bookmarkPath)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	if !name.IsBookmark() {
+		return Bookmark{}, NameError{bookmarkPath, "missing '#' delimiter"}
+	}
+
+	return z.newBookmark(bookmarkPath), nil
+}
+
+// Builds Bookmark from path returned by zfs
+func (z Zfs) newBookmark(bookmarkPath string) Bookmark {
+	fsPath, name, _ := splitBookmarkPath(bookmarkPath)
+	return Bookmark{zfsEntryBase{z, bookmarkPath}, z.NewFs(fsPath), name}
+}
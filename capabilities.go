@@ -0,0 +1,191 @@
+package zfs
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature which exists only on some zfs versions or pools
+type Capability struct {
+	Name string
+
+	// First OpenZFS version supporting capability
+	Major, Minor int
+
+	// Pool feature required by capability, without 'feature@' prefix
+	PoolFeature string
+}
+
+var (
+	CapCompressedSend = Capability{Name: "compressed send (-c)", Minor: 7}
+	CapRawSend        = Capability{Name: "raw send (-w)", Minor: 8}
+	CapEncryption     = Capability{
+		Name: "native encryption", Minor: 8, PoolFeature: "encryption",
+	}
+	CapJSON = Capability{Name: "JSON output (-j)", Major: 2, Minor: 3}
+
+	// Commands not wrapped by package, for callers running them with
+	// Zfs.Command
+	CapWait   = Capability{Name: "zfs wait", Major: 2}
+	CapRedact = Capability{
+		Name: "zfs redact", Major: 2, PoolFeature: "redaction_bookmarks",
+	}
+)
+
+// Returned when capability is not supported by zfs on host or by pool
+type UnsupportedError struct {
+	Capability Capability
+	Version    ZfsVersion
+	Pool       string
+}
+
+func (e UnsupportedError) Error() string {
+	version := "zfs " + e.Version.String()
+	if e.Version.Userland == "" {
+		version = "zfs older than 0.8"
+	}
+
+	if e.Pool != "" {
+		return e.Capability.Name + " unsupported on this host: pool '" +
+			e.Pool + "' has no feature " + e.Capability.PoolFeature
+	}
+
+	return e.Capability.Name + " unsupported on this host: " + version +
+		", need " + strconv.Itoa(e.Capability.Major) + "." +
+		strconv.Itoa(e.Capability.Minor)
+}
+
+// Zfs version and pool features of host
+type Capabilities struct {
+	// Zero if zfs is older than 0.8 and has no 'zfs version' command
+	Version ZfsVersion
+
+	// Feature states (disabled, enabled or active) by pool and feature name
+	Features map[string]map[string]string
+}
+
+// Returns nil if capability is supported by zfs and given pool. Pool
+// features are not checked if pool is empty.
+func (c Capabilities) Check(capability Capability, pool string) error {
+	supported := c.Version.AtLeast(capability.Major, capability.Minor, 0)
+	if c.Version.Userland == "" {
+		// 'zfs version' appeared in 0.8
+		supported = capability.Major == 0 && capability.Minor < 8
+	}
+
+	if !supported {
+		return UnsupportedError{Capability: capability, Version: c.Version}
+	}
+
+	if pool == "" || capability.PoolFeature == "" {
+		return nil
+	}
+
+	switch c.Features[pool][capability.PoolFeature] {
+	case "enabled", "active":
+		return nil
+	default:
+		return UnsupportedError{capability, c.Version, pool}
+	}
+}
+
+func (c Capabilities) Supports(capability Capability, pool string) bool {
+	return c.Check(capability, pool) == nil
+}
+
+// Shared between copies of runner, like ones returned by DryRun
+type capabilitiesCache struct {
+	mutex        sync.Mutex
+	capabilities *Capabilities
+
+	// JSON output is supported, see Zfs.useJSON
+	json *bool
+}
+
+// Returns error if capability is not supported by zfs on host or by pool
+func (z Zfs) check(capability Capability, pool string) error {
+	capabilities, err := z.Capabilities()
+	if err != nil {
+		return errors.New("error detecting zfs capabilities: " + err.Error())
+	}
+
+	return capabilities.Check(capability, pool)
+}
+
+// Returns capabilities of host, they are detected once per runner
+func (z Zfs) Capabilities() (Capabilities, error) {
+	if z.capabilities == nil {
+		return z.detectCapabilities()
+	}
+
+	z.capabilities.mutex.Lock()
+	defer z.capabilities.mutex.Unlock()
+
+	if z.capabilities.capabilities != nil {
+		return *z.capabilities.capabilities, nil
+	}
+
+	capabilities, err := z.detectCapabilities()
+	if err != nil {
+		return capabilities, err
+	}
+
+	z.capabilities.capabilities = &capabilities
+	return capabilities, nil
+}
+
+func (z Zfs) detectCapabilities() (Capabilities, error) {
+	capabilities := Capabilities{Features: map[string]map[string]string{}}
+
+	version, err := z.Version()
+	if err != nil && !strings.Contains(err.Error(), "unrecognized command") {
+		return capabilities, err
+	}
+	capabilities.Version = version
+
+	c := z.Command("zpool", "get", "-H", "-o", "name,property,value", "all")
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return capabilities, parseError(err, stderr)
+	}
+
+	capabilities.Features = parseFeatures(string(stdout))
+	return capabilities, nil
+}
+
+func parseFeatures(output string) map[string]map[string]string {
+	features := map[string]map[string]string{}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || !strings.HasPrefix(fields[1], "feature@") {
+			continue
+		}
+
+		if features[fields[0]] == nil {
+			features[fields[0]] = map[string]string{}
+		}
+		features[fields[0]][strings.TrimPrefix(fields[1], "feature@")] = fields[2]
+	}
+
+	return features
+}
+
+// Fails early if options need flags unsupported by host
+func (o SendOptions) check(s Snapshot) error {
+	if o.Raw {
+		if err := s.runner.check(CapRawSend, ""); err != nil {
+			return err
+		}
+	}
+	if o.Compressed {
+		if err := s.runner.check(CapCompressedSend, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package zfs
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/theairkit/runcmd"
+)
+
+// Configures Zfs created by New
+type Option func(*ZfsRunner) error
+
+// Creates Zfs with given options. Without WithRunner commands are run on
+// local host and without WithEscalation they are run as is.
+func New(opts ...Option) (Zfs, error) {
+	runner := &ZfsRunner{
+		kind:         RunnerLocal,
+		capabilities: &capabilitiesCache{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(runner); err != nil {
+			return Zfs{}, err
+		}
+	}
+
+	if runner.Runner == nil {
+		local, err := runcmd.NewLocalRunner()
+		if err != nil {
+			return Zfs{}, errors.New(
+				"error creating local runner: " + err.Error(),
+			)
+		}
+		runner.Runner = local
+	}
+
+	return Zfs{runner}, nil
+}
+
+// Runs commands with given runner, for example remote one
+func WithRunner(runner runcmd.Runner) Option {
+	return func(z *ZfsRunner) error {
+		if runner == nil {
+			return errors.New("runner is nil")
+		}
+
+		z.Runner = runner
+		z.kind = runnerKind(runner)
+		return nil
+	}
+}
+
+func WithEscalation(escalation Escalation) Option {
+	return func(z *ZfsRunner) error {
+		z.escalation = escalation
+		return nil
+	}
+}
+
+// See ZfsRunner.SetObserver
+func WithObserver(observer Observer, redactors ...Redactor) Option {
+	return func(z *ZfsRunner) error {
+		z.observer = observer
+		z.redactors = redactors
+		return nil
+	}
+}
+
+// Logs all commands to logger with secret properties and share options
+// redacted
+func WithLogger(logger *slog.Logger) Option {
+	return WithObserver(SlogObserver{logger})
+}
+
+// Stops waiting for commands running longer than timeout and returns
+// TimeoutError. Command is killed if runner worker supports it, see
+// Killer. Send and receive are not limited, since they run as long as
+// stream is copied, neither is zfs wait.
+func WithTimeout(timeout time.Duration) Option {
+	return func(z *ZfsRunner) error {
+		if timeout <= 0 {
+			return errors.New("timeout should be positive")
+		}
+
+		z.timeout = timeout
+		return nil
+	}
+}
+
+// Returned when command is not finished in time set with WithTimeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	return "command timed out after " + e.Timeout.String()
+}
+
+// Implemented by runner workers which can be stopped, timed out commands
+// of other workers are left running
+type Killer interface {
+	Kill() error
+}
+
+// Returns true if command should be limited by timeout
+func (z ZfsRunner) timed(name string, args []string) bool {
+	if z.timeout == 0 || (name != "zfs" && name != "zpool") {
+		return false
+	}
+
+	return len(args) == 0 || !streamingCommands[args[0]]
+}
+
+var streamingCommands = map[string]bool{
+	"send": true, "receive": true, "recv": true, "wait": true,
+}
+
+// Waits for command in background and kills process on timeout
+type timeoutWorker struct {
+	runcmd.CmdWorker
+
+	// worker returned by runner, checked for Killer
+	process runcmd.CmdWorker
+	timeout time.Duration
+}
+
+type outputResult struct {
+	stdout []byte
+	stderr []byte
+	err    error
+}
+
+func (w timeoutWorker) Output() ([]byte, []byte, error) {
+	done := make(chan outputResult, 1)
+	go func() {
+		stdout, stderr, err := w.CmdWorker.Output()
+		done <- outputResult{stdout, stderr, err}
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return result.stdout, result.stderr, result.err
+	case <-timer.C:
+		w.kill()
+		return nil, nil, TimeoutError{w.timeout}
+	}
+}
+
+func (w timeoutWorker) Wait() error {
+	done := make(chan error, 1)
+	go func() {
+		done <- w.CmdWorker.Wait()
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		w.kill()
+		return TimeoutError{w.timeout}
+	}
+}
+
+func (w timeoutWorker) kill() {
+	if killer, ok := w.process.(Killer); ok {
+		killer.Kill()
+	}
+}
+
+var (
+	stdMutex sync.Mutex
+	stdZfs   *Zfs
+)
+
+// Returns standard Zfs used by package level functions, created on first
+// use. If local runner can't be created, all its commands fail.
+func std() Zfs {
+	stdMutex.Lock()
+	defer stdMutex.Unlock()
+
+	if stdZfs == nil {
+		z, err := New()
+		if err != nil {
+			z = NewZfs(failedRunner{err}, false)
+		}
+		z.mutex = &sync.RWMutex{}
+		stdZfs = &z
+	}
+
+	return *stdZfs
+}
+
+// Changes settings of standard runner in place, so objects created before
+// see them too
+func configureStd(configure func(*ZfsRunner)) {
+	z := std()
+
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	configure(z.ZfsRunner)
+}
+
+// Replaces standard Zfs used by package level functions with copy of z.
+// Objects created from previous standard Zfs keep using it.
+func SetStd(z Zfs) {
+	runner := z.current()
+	runner.mutex = &sync.RWMutex{}
+
+	stdMutex.Lock()
+	defer stdMutex.Unlock()
+
+	stdZfs = &Zfs{&runner}
+}
+
+type failedRunner struct {
+	err error
+}
+
+func (r failedRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	return failedWorker{err: r.err}
+}
+
+// Fails every call with runner creation error
+type failedWorker struct {
+	err error
+}
+
+func (w failedWorker) Run() ([]string, error) {
+	return nil, w.err
+}
+
+func (w failedWorker) Output() ([]byte, []byte, error) {
+	return nil, nil, w.err
+}
+
+func (w failedWorker) Start() error {
+	return w.err
+}
+
+func (w failedWorker) Wait() error {
+	return w.err
+}
+
+func (w failedWorker) StdinPipe() (io.WriteCloser, error) {
+	return nil, w.err
+}
+
+func (w failedWorker) StdoutPipe() (io.Reader, error) {
+	return nil, w.err
+}
+
+func (w failedWorker) StderrPipe() (io.Reader, error) {
+	return nil, w.err
+}
+
+func (w failedWorker) SetStdout(io.Writer) {
+}
+
+func (w failedWorker) GetCommandLine() string {
+	return ""
+}
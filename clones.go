@@ -0,0 +1,109 @@
+package zfs
+
+import (
+	"sort"
+	"strings"
+)
+
+// Returns snapshot filesystem was cloned from, nil if it is not a clone
+func (f Fs) Origin() (*Snapshot, error) {
+	origin, err := f.GetProperty("origin")
+	if err != nil {
+		return nil, err
+	}
+
+	if origin == "" || origin == "-" {
+		return nil, nil
+	}
+
+	snap := f.runner.newSnapshot(origin)
+	return &snap, nil
+}
+
+// Clone/origin dependencies between datasets
+type CloneGraph struct {
+	// Origin snapshot by clone path
+	Origins map[string]Snapshot
+
+	// Clones sorted by path by origin snapshot path
+	Clones map[string][]Fs
+}
+
+// See Zfs.ListCloneGraph
+func ListCloneGraph(path string) (CloneGraph, error) {
+	return std().ListCloneGraph(path)
+}
+
+// Builds clone graph for path and all its descendents with one zfs list
+// call, pass pool name to get graph for whole pool
+func (z Zfs) ListCloneGraph(path string) (CloneGraph, error) {
+	graph := CloneGraph{
+		Origins: map[string]Snapshot{},
+		Clones:  map[string][]Fs{},
+	}
+
+	datasets, err := z.List(ListOptions{
+		Paths:      []string{path},
+		Types:      []DatasetType{TypeFilesystem, TypeVolume},
+		Recursive:  true,
+		Properties: []string{"origin"},
+	})
+	if err != nil {
+		return graph, err
+	}
+
+	for _, dataset := range datasets {
+		origin, _ := dataset.Property("origin")
+		if origin == "" || origin == "-" {
+			continue
+		}
+
+		clone := z.NewFs(dataset.getPath())
+		graph.Origins[clone.Path] = z.newSnapshot(origin)
+		graph.Clones[origin] = append(graph.Clones[origin], clone)
+	}
+
+	for origin := range graph.Clones {
+		clones := graph.Clones[origin]
+		sort.Slice(clones, func(i, j int) bool {
+			return clones[i].Path < clones[j].Path
+		})
+	}
+
+	return graph, nil
+}
+
+// Returns all clones depending on snapshot: its clones, clones of
+// snapshots of these clones and their descendents, and so on
+func (g CloneGraph) Dependents(snapshotPath string) []Fs {
+	origins := []string{}
+	for origin := range g.Clones {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	dependents := []Fs{}
+	visited := map[string]bool{}
+	queue := []string{snapshotPath}
+	for len(queue) > 0 {
+		clones := g.Clones[queue[0]]
+		queue = queue[1:]
+
+		for _, clone := range clones {
+			if visited[clone.Path] {
+				continue
+			}
+			visited[clone.Path] = true
+			dependents = append(dependents, clone)
+
+			for _, origin := range origins {
+				if strings.HasPrefix(origin, clone.Path+"@") ||
+					strings.HasPrefix(origin, clone.Path+"/") {
+					queue = append(queue, origin)
+				}
+			}
+		}
+	}
+
+	return dependents
+}
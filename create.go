@@ -0,0 +1,150 @@
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+type CreateOptions struct {
+	// Properties set on creation (-o), like mountpoint or compression
+	Props map[string]string
+
+	// Create missing parent datasets
+	Parents bool
+
+	// Do not mount created filesystem (-u)
+	NoMount bool
+
+	// Only check that dataset can be created (-n)
+	DryRun bool
+
+	// Create sparse volume (-s), used only by CreateVolume
+	Sparse bool
+
+	// Native encryption settings
+	Encryption EncryptionOptions
+}
+
+func (o CreateOptions) args() []string {
+	args := []string{"create"}
+
+	if o.DryRun {
+		args = append(args, "-n", "-v")
+	}
+	if o.NoMount {
+		args = append(args, "-u")
+	}
+
+	keys := []string{}
+	for key := range o.Props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		args = append(args, "-o", key+"="+o.Props[key])
+	}
+
+	return append(args, o.Encryption.args()...)
+}
+
+// See Zfs.CreateFsWithOptions
+func CreateFsWithOptions(zfsPath string, opts CreateOptions) (Fs, error) {
+	return std().CreateFsWithOptions(zfsPath, opts)
+}
+
+// Creates filesystem with given properties. Existing filesystem is
+// detected by zfs create itself, parents are created separately, since
+// 'zfs create -p' silently succeeds for existing datasets.
+func (z Zfs) CreateFsWithOptions(
+	zfsPath string, opts CreateOptions,
+) (Fs, error) {
+	fs := z.NewFs(zfsPath)
+	if err := checkDatasetName(zfsPath); err != nil {
+		return fs, err
+	}
+
+	return fs, z.create(zfsPath, opts)
+}
+
+// See Zfs.CreateVolume
+func CreateVolume(
+	volumePath string, size int64, opts CreateOptions,
+) (Volume, error) {
+	return std().CreateVolume(volumePath, size, opts)
+}
+
+// Creates volume of given size in bytes
+func (z Zfs) CreateVolume(
+	volumePath string, size int64, opts CreateOptions,
+) (Volume, error) {
+	volume := z.NewVolume(volumePath)
+	if err := checkDatasetName(volumePath); err != nil {
+		return volume, err
+	}
+
+	args := []string{"-V", strconv.FormatInt(size, 10)}
+	if opts.Sparse {
+		args = append(args, "-s")
+	}
+
+	return volume, z.create(volumePath, opts, args...)
+}
+
+func (z Zfs) create(path string, opts CreateOptions, extra ...string) error {
+	if opts.Encryption.enabled() {
+		if err := z.check(CapEncryption, PoolName(path)); err != nil {
+			return err
+		}
+	}
+
+	args := append(opts.args(), extra...)
+	if opts.Parents {
+		if opts.DryRun {
+			args = append(args, "-p")
+		} else if err := z.createParents(path, opts); err != nil {
+			return err
+		}
+	}
+
+	c := z.Command("zfs", append(args, path)...)
+
+	if opts.Encryption.Key != nil {
+		return createError(path, runWithInput(c, opts.Encryption.Key))
+	}
+
+	_, stderr, err := c.Output()
+	return createError(path, parseError(err, stderr))
+}
+
+func (z Zfs) createParents(zfsPath string, opts CreateOptions) error {
+	parent, ok := ParentPath(zfsPath)
+	if !ok {
+		return nil
+	}
+
+	// pool always exists
+	if _, ok := ParentPath(parent); !ok {
+		return nil
+	}
+
+	args := []string{"create", "-p"}
+	if opts.NoMount {
+		args = append(args, "-u")
+	}
+
+	c := z.Command("zfs", append(args, parent)...)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+func createError(zfsPath string, err error) error {
+	if err != nil && DatasetExists.MatchString(err.Error()) {
+		return errors.New(fmt.Sprintf("fs %s already exists", zfsPath))
+	}
+
+	return err
+}
@@ -0,0 +1,187 @@
+package zfs
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type DestroyOptions struct {
+	// RF_Soft destroys children, RF_Hard also destroys clones anywhere in
+	// pool
+	Recursive RecursiveFlag
+
+	// Only report what would be destroyed
+	DryRun bool
+
+	// Mark snapshots for deferred destruction if they have holds or clones
+	Defer bool
+
+	// Forcibly unmount filesystems
+	Force bool
+
+	// Allow DestroySnapshotRange with empty first or last snapshot
+	OpenRange bool
+}
+
+func (o DestroyOptions) args() []string {
+	args := []string{"destroy"}
+
+	if o.DryRun {
+		args = append(args, "-nvp")
+	} else {
+		args = append(args, "-vp")
+	}
+
+	switch o.Recursive {
+	case RF_Soft:
+		args = append(args, "-r")
+	case RF_Hard:
+		args = append(args, "-R")
+	}
+
+	if o.Defer {
+		args = append(args, "-d")
+	}
+	if o.Force {
+		args = append(args, "-f")
+	}
+
+	return args
+}
+
+// Datasets destroyed, or which would be destroyed in dry-run, as
+// reported by zfs
+type DestroyPlan struct {
+	Snapshots []string
+	Bookmarks []string
+
+	// Filesystems and volumes
+	Filesystems []string
+
+	// Filesystems which are clones of snapshots, filled only in dry-run,
+	// since destroyed datasets can't be queried
+	Clones []string
+
+	// Bytes reclaimed by destroy
+	Reclaimed int64
+}
+
+// Returns all datasets in plan
+func (p DestroyPlan) Datasets() []string {
+	datasets := append([]string{}, p.Filesystems...)
+	datasets = append(datasets, p.Snapshots...)
+	return append(datasets, p.Bookmarks...)
+}
+
+// Parses output of 'zfs destroy -vp'
+func parseDestroyPlan(output string) (DestroyPlan, error) {
+	plan := DestroyPlan{}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "destroy":
+			switch {
+			case strings.Contains(fields[1], "@"):
+				plan.Snapshots = append(plan.Snapshots, fields[1])
+			case strings.Contains(fields[1], "#"):
+				plan.Bookmarks = append(plan.Bookmarks, fields[1])
+			default:
+				plan.Filesystems = append(plan.Filesystems, fields[1])
+			}
+
+		case "reclaim":
+			reclaim, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return plan, errors.New(
+					"error parsing reclaimed space: " + err.Error(),
+				)
+			}
+			plan.Reclaimed += reclaim
+		}
+	}
+
+	return plan, nil
+}
+
+func (z zfsEntryBase) DestroyWithOptions(
+	opts DestroyOptions,
+) (DestroyPlan, error) {
+	return z.destroy(z.Path, opts)
+}
+
+func (z zfsEntryBase) destroy(
+	path string, opts DestroyOptions,
+) (DestroyPlan, error) {
+	c := z.runner.Command("zfs", append(opts.args(), path)...)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return DestroyPlan{}, parseError(err, stderr)
+	}
+
+	plan, err := parseDestroyPlan(string(stdout))
+	if err != nil || !opts.DryRun || len(plan.Filesystems) == 0 {
+		return plan, err
+	}
+
+	plan.Clones, err = z.runner.clones(plan.Filesystems)
+	return plan, err
+}
+
+// Destroys range of snapshots from first to last inclusive. With
+// OpenRange option one of them may be empty to destroy all snapshots
+// before or after other one.
+func (f Fs) DestroySnapshotRange(
+	first, last string, opts DestroyOptions,
+) (DestroyPlan, error) {
+	if first == "" && last == "" {
+		return DestroyPlan{}, errors.New(
+			"snapshot range of " + f.Path + " has no bounds",
+		)
+	}
+	if (first == "" || last == "") && !opts.OpenRange {
+		return DestroyPlan{}, errors.New(
+			"snapshot range of " + f.Path + " is open, use OpenRange option",
+		)
+	}
+
+	for _, name := range []string{first, last} {
+		if name == "" {
+			continue
+		}
+		if _, err := ParseName(f.Path + "@" + name); err != nil {
+			return DestroyPlan{}, err
+		}
+	}
+
+	return f.destroy(f.Path+"@"+first+"%"+last, opts)
+}
+
+// Returns datasets from given list which have origin
+func (z Zfs) clones(datasets []string) ([]string, error) {
+	c := z.Command(
+		"zfs", append([]string{"get", "-Hp", "-o", "name,value", "origin"},
+			datasets...)...,
+	)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return nil, parseError(err, stderr)
+	}
+
+	clones := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 2 && fields[1] != "-" {
+			clones = append(clones, fields[0])
+		}
+	}
+
+	return clones, nil
+}
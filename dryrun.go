@@ -0,0 +1,220 @@
+package zfs
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/theairkit/runcmd"
+)
+
+// zfs subcommands changing pool state, recorded instead of running in
+// dry-run mode
+var mutatingCommands = map[string]bool{
+	"create": true, "destroy": true, "snapshot": true, "snap": true,
+	"rollback": true, "clone": true, "promote": true, "rename": true,
+	"set": true, "inherit": true, "receive": true, "recv": true,
+	"mount": true, "unmount": true, "umount": true, "share": true,
+	"unshare": true, "allow": true, "unallow": true, "load-key": true,
+	"unload-key": true, "change-key": true, "hold": true, "release": true,
+	"bookmark": true, "upgrade": true, "redact": true,
+}
+
+// Commands and destroy reports collected by dry-run Zfs
+type Plan struct {
+	mutex     sync.Mutex
+	commands  [][]string
+	destroyed []string
+	reclaimed int64
+	sent      int64
+}
+
+// Returns copy of z which doesn't change anything: mutating commands are
+// recorded in returned plan and reported as succeeded. Destroy is checked
+// with 'zfs destroy -nvp', so plan contains datasets which would be
+// destroyed and space which would be reclaimed. Sends are recorded too and
+// estimated with 'zfs send -nvP' instead of writing stream.
+func (z Zfs) DryRun() (Zfs, *Plan) {
+	plan := &Plan{}
+
+	runner := z.current()
+	runner.plan = plan
+	runner.mutex = nil
+
+	return Zfs{&runner}, plan
+}
+
+// Returns true if z is created by DryRun
+func (z *ZfsRunner) IsDryRun() bool {
+	return z.current().plan != nil
+}
+
+// Commands which would be run, with their arguments
+func (p *Plan) Commands() [][]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([][]string{}, p.commands...)
+}
+
+// Datasets which would be destroyed, as reported by zfs
+func (p *Plan) Destroyed() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]string{}, p.destroyed...)
+}
+
+// Bytes which would be reclaimed by planned destroys
+func (p *Plan) Reclaimed() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.reclaimed
+}
+
+// Bytes which would be sent by planned sends, as estimated by zfs
+func (p *Plan) Sent() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.sent
+}
+
+func (p *Plan) String() string {
+	lines := []string{}
+	for _, command := range p.Commands() {
+		lines = append(lines, strings.Join(command, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (p *Plan) record(command []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.commands = append(p.commands, command)
+}
+
+func (p *Plan) recordSend(command []string, size int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.commands = append(p.commands, command)
+	p.sent += size
+}
+
+func (p *Plan) recordDestroy(output string) error {
+	destroy, err := parseDestroyPlan(output)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.destroyed = append(p.destroyed, destroy.Datasets()...)
+	p.reclaimed += destroy.Reclaimed
+
+	return nil
+}
+
+func isMutating(name string, args []string) bool {
+	if name != "zfs" || len(args) == 0 || !mutatingCommands[args[0]] {
+		return false
+	}
+
+	// destroy with -n only reports what would be destroyed
+	return args[0] != "destroy" || len(args) < 2 || args[1] != "-nvp"
+}
+
+// Records send with its estimated size instead of running it
+func (z *ZfsRunner) planSend(args []string, size int64) {
+	runner := z.current()
+
+	name, args := runner.argv("zfs", args)
+	runner.plan.recordSend(append([]string{name}, args...), size)
+}
+
+// Returns worker for dry-run mode, args are not wrapped yet
+func (z ZfsRunner) planned(name string, args []string) runcmd.CmdWorker {
+	planned, plannedArgs := z.argv(name, args)
+	z.plan.record(append([]string{planned}, plannedArgs...))
+
+	if args[0] == "destroy" {
+		check := []string{"destroy", "-nvp"}
+		for _, arg := range args[1:] {
+			if arg != "-vp" {
+				check = append(check, arg)
+			}
+		}
+		return destroyCheckWorker{z.command(name, check), z.plan}
+	}
+
+	return plannedWorker{strings.Join(append([]string{planned}, plannedArgs...), " ")}
+}
+
+// Reports success without running command, pipes are in memory: stdin is
+// discarded and output is empty
+type plannedWorker struct {
+	commandLine string
+}
+
+func (w plannedWorker) Run() ([]string, error) {
+	return []string{}, nil
+}
+
+func (w plannedWorker) Output() ([]byte, []byte, error) {
+	return nil, nil, nil
+}
+
+func (w plannedWorker) Start() error {
+	return nil
+}
+
+func (w plannedWorker) Wait() error {
+	return nil
+}
+
+func (w plannedWorker) StdinPipe() (io.WriteCloser, error) {
+	return discardCloser{io.Discard}, nil
+}
+
+func (w plannedWorker) StdoutPipe() (io.Reader, error) {
+	return strings.NewReader(""), nil
+}
+
+func (w plannedWorker) StderrPipe() (io.Reader, error) {
+	return strings.NewReader(""), nil
+}
+
+func (w plannedWorker) SetStdout(io.Writer) {
+}
+
+func (w plannedWorker) GetCommandLine() string {
+	return w.commandLine
+}
+
+// Runs 'zfs destroy -nvp' instead of destroy and stores its report in plan
+type destroyCheckWorker struct {
+	runcmd.CmdWorker
+	plan *Plan
+}
+
+func (w destroyCheckWorker) Output() ([]byte, []byte, error) {
+	stdout, stderr, err := w.CmdWorker.Output()
+	if err != nil {
+		return nil, stderr, err
+	}
+
+	return stdout, stderr, w.plan.recordDestroy(string(stdout))
+}
+
+type discardCloser struct {
+	io.Writer
+}
+
+func (discardCloser) Close() error {
+	return nil
+}
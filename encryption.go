@@ -0,0 +1,241 @@
+package zfs
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/theairkit/runcmd"
+)
+
+// Native encryption settings used on dataset creation
+type EncryptionOptions struct {
+	// Encryption algorithm, "on" for default one
+	Algorithm string
+
+	// raw, hex or passphrase
+	KeyFormat string
+
+	// prompt or file:///path/to/key
+	KeyLocation string
+
+	// Number of PBKDF2 iterations for passphrase keys, zfs default if zero
+	PBKDF2Iters int
+
+	// Key material passed to zfs through stdin, used with prompt location.
+	// Key never gets into command arguments.
+	Key io.Reader
+}
+
+// Key options enable encryption with default algorithm, so any of them
+// requires encryption support
+func (o EncryptionOptions) enabled() bool {
+	if o.Algorithm != "" {
+		return o.Algorithm != "off"
+	}
+
+	return o.KeyFormat != "" || o.KeyLocation != "" || o.PBKDF2Iters != 0 ||
+		o.Key != nil
+}
+
+func (o EncryptionOptions) args() []string {
+	args := []string{}
+
+	if o.Algorithm != "" {
+		args = append(args, "-o", "encryption="+o.Algorithm)
+	}
+
+	return append(args, keyArgs(o.KeyFormat, o.KeyLocation, o.PBKDF2Iters)...)
+}
+
+func keyArgs(format, location string, iters int) []string {
+	args := []string{}
+
+	if format != "" {
+		args = append(args, "-o", "keyformat="+format)
+	}
+	if location != "" {
+		args = append(args, "-o", "keylocation="+location)
+	}
+	if iters > 0 {
+		args = append(args, "-o", "pbkdf2iters="+strconv.Itoa(iters))
+	}
+
+	return args
+}
+
+type KeyStatus string
+
+const (
+	KeyAvailable   KeyStatus = "available"
+	KeyUnavailable KeyStatus = "unavailable"
+
+	// dataset is not encrypted
+	KeyNone KeyStatus = "-"
+)
+
+type LoadKeyOptions struct {
+	// Key material passed through stdin, keylocation must be prompt or
+	// Location must be set to prompt
+	Key io.Reader
+
+	// Overrides keylocation property (-L)
+	Location string
+
+	// Load keys of all encryption roots below filesystem (-r)
+	Recursive bool
+
+	// Only check that key is correct (-n)
+	DryRun bool
+}
+
+func (f Fs) LoadKey(opts LoadKeyOptions) error {
+	args := []string{"load-key"}
+
+	if opts.Recursive {
+		args = append(args, "-r")
+	}
+	if opts.DryRun {
+		args = append(args, "-n")
+	}
+	if opts.Location != "" {
+		args = append(args, "-L", opts.Location)
+	}
+
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	if opts.Key != nil {
+		return runWithInput(c, opts.Key)
+	}
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// Unloads key of filesystem, or keys of all encryption roots below it if
+// recursive is set. Filesystems must be unmounted.
+func (f Fs) UnloadKey(recursive bool) error {
+	args := []string{"unload-key"}
+	if recursive {
+		args = append(args, "-r")
+	}
+
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+type ChangeKeyOptions struct {
+	KeyFormat   string
+	KeyLocation string
+	PBKDF2Iters int
+
+	// New key material passed through stdin
+	Key io.Reader
+
+	// Load key before changing it (-l)
+	Load bool
+
+	// Inherit key from parent encryption root (-i), other options are
+	// ignored
+	Inherit bool
+}
+
+func (f Fs) ChangeKey(opts ChangeKeyOptions) error {
+	args := []string{"change-key"}
+
+	if opts.Load {
+		args = append(args, "-l")
+	}
+
+	if opts.Inherit {
+		args = append(args, "-i")
+	} else {
+		args = append(
+			args, keyArgs(opts.KeyFormat, opts.KeyLocation, opts.PBKDF2Iters)...,
+		)
+	}
+
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	if opts.Key != nil && !opts.Inherit {
+		return runWithInput(c, opts.Key)
+	}
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+func (f Fs) KeyStatus() (KeyStatus, error) {
+	status, err := f.GetProperty("keystatus")
+	if err != nil {
+		return KeyNone, err
+	}
+
+	if status == "" {
+		return KeyNone, nil
+	}
+
+	return KeyStatus(status), nil
+}
+
+// Returns key status of filesystem and all its descendents by path
+func (f Fs) KeyStatusRecursive() (map[string]KeyStatus, error) {
+	statuses := map[string]KeyStatus{}
+
+	datasets, err := f.runner.List(ListOptions{
+		Paths:      []string{f.Path},
+		Types:      []DatasetType{TypeFilesystem, TypeVolume},
+		Recursive:  true,
+		Properties: []string{"keystatus"},
+	})
+	if err != nil {
+		return statuses, err
+	}
+
+	for _, dataset := range datasets {
+		status, _ := dataset.Property("keystatus")
+		if status == "" {
+			status = string(KeyNone)
+		}
+		statuses[dataset.getPath()] = KeyStatus(status)
+	}
+
+	return statuses, nil
+}
+
+// Runs command feeding input to its stdin, used to pass secrets without
+// putting them into command arguments
+func runWithInput(c runcmd.CmdWorker, input io.Reader) error {
+	stdinPipe, err := c.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// runner may capture stderr itself, then it is only in Wait error
+	stderr := &bytes.Buffer{}
+	stderrDone := make(chan struct{})
+	if stderrPipe, err := c.StderrPipe(); err == nil {
+		go func() {
+			io.Copy(stderr, stderrPipe)
+			close(stderrDone)
+		}()
+	} else {
+		close(stderrDone)
+	}
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	_, copyErr := io.Copy(stdinPipe, input)
+	stdinPipe.Close()
+
+	<-stderrDone
+	if err := c.Wait(); err != nil {
+		return parseError(err, stderr.Bytes())
+	}
+
+	return copyErr
+}
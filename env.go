@@ -0,0 +1,188 @@
+package zfs
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Runs zfs from given path instead of looking it up in PATH
+func WithZfsBinary(path string) Option {
+	return withBinary("zfs", path)
+}
+
+// Runs zpool from given path instead of looking it up in PATH
+func WithZpoolBinary(path string) Option {
+	return withBinary("zpool", path)
+}
+
+func withBinary(name, path string) Option {
+	return func(z *ZfsRunner) error {
+		if path == "" {
+			return errors.New(name + " binary path is empty")
+		}
+
+		binaries := map[string]string{}
+		for command, binary := range z.binaries {
+			binaries[command] = binary
+		}
+		binaries[name] = path
+
+		z.binaries = binaries
+		return nil
+	}
+}
+
+// Sets environment variables for every command. Commands are run with
+// env(1) before escalation, so escalation rules still see zfs itself;
+// sudo keeps only variables allowed by env_keep, LC_* are kept by default.
+func WithEnv(env map[string]string) Option {
+	return func(z *ZfsRunner) error {
+		merged := map[string]string{}
+		for name, value := range z.env {
+			merged[name] = value
+		}
+
+		for name, value := range env {
+			if !envName.MatchString(name) {
+				return errors.New("invalid environment variable name '" +
+					name + "'")
+			}
+			merged[name] = value
+		}
+
+		z.env = merged
+		return nil
+	}
+}
+
+// Unsets environment variables for every command
+func WithoutEnv(names ...string) Option {
+	return func(z *ZfsRunner) error {
+		for _, name := range names {
+			if !envName.MatchString(name) {
+				return errors.New("invalid environment variable name '" +
+					name + "'")
+			}
+		}
+
+		z.unsetEnv = append(append([]string{}, z.unsetEnv...), names...)
+		return nil
+	}
+}
+
+// Runs commands with C locale and without colors, so zfs messages match
+// error regexps of this package
+func WithPlainOutput() Option {
+	return func(z *ZfsRunner) error {
+		if err := WithEnv(map[string]string{"LC_ALL": "C"})(z); err != nil {
+			return err
+		}
+
+		return WithoutEnv("ZFS_COLOR")(z)
+	}
+}
+
+func (z ZfsRunner) envArgs(name string, args []string) (string, []string) {
+	if len(z.env) == 0 && len(z.unsetEnv) == 0 {
+		return name, args
+	}
+
+	envArgs := []string{}
+	for _, unset := range z.unsetEnv {
+		envArgs = append(envArgs, "-u", unset)
+	}
+
+	names := []string{}
+	for env := range z.env {
+		names = append(names, env)
+	}
+	sort.Strings(names)
+
+	for _, env := range names {
+		envArgs = append(envArgs, env+"="+z.env[env])
+	}
+
+	return "env", append(append(envArgs, name), args...)
+}
+
+// Versions of zfs userland tools and kernel module
+type ZfsVersion struct {
+	// Like zfs-2.1.5-1ubuntu6
+	Userland string
+
+	// Like zfs-kmod-2.1.5-1ubuntu6, empty if module is not loaded
+	Kernel string
+
+	// Userland version numbers
+	Major int
+	Minor int
+	Patch int
+}
+
+var zfsVersion = regexp.MustCompile(`^zfs-(?:kmod-)?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Returns true if userland version is at least major.minor.patch
+func (v ZfsVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+
+	return v.Patch >= patch
+}
+
+func (v ZfsVersion) String() string {
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." +
+		strconv.Itoa(v.Patch)
+}
+
+// See Zfs.Version
+func Version() (ZfsVersion, error) {
+	return std().Version()
+}
+
+// Runs 'zfs version', which exists since OpenZFS 0.8
+func (z Zfs) Version() (ZfsVersion, error) {
+	c := z.Command("zfs", "version")
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return ZfsVersion{}, parseError(err, stderr)
+	}
+
+	return parseVersion(string(stdout))
+}
+
+func parseVersion(output string) (ZfsVersion, error) {
+	version := ZfsVersion{}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "zfs-kmod-"):
+			version.Kernel = line
+		case strings.HasPrefix(line, "zfs-"):
+			version.Userland = line
+		}
+	}
+
+	numbers := zfsVersion.FindStringSubmatch(version.Userland)
+	if numbers == nil {
+		return version, errors.New(
+			"error parsing zfs version: '" + strings.TrimSpace(output) + "'",
+		)
+	}
+
+	version.Major, _ = strconv.Atoi(numbers[1])
+	version.Minor, _ = strconv.Atoi(numbers[2])
+	version.Patch, _ = strconv.Atoi(numbers[3])
+
+	return version, nil
+}
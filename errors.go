@@ -14,13 +14,20 @@ var (
 	NotMounted         = regexp.MustCompile(`^filesystem successfully created, but not mounted`)
 	NeedSudo           = regexp.MustCompile(`need sudo`)
 	AllreadyExists     = regexp.MustCompile(`fs .+ already exists$`)
+	DatasetExists      = regexp.MustCompile(`cannot create '.+': dataset already exists$`)
 	PromoteNotClone    = regexp.MustCompile(`cannot promote '.+': not a cloned filesystem$`)
 	InvalidDataset     = regexp.MustCompile(`invalid( dataset)? name$`)
 	ReceiverExists     = regexp.MustCompile(`cannot receive new filesystem stream: destination '.+' exists$`)
 	MostRecentNotMatch = regexp.MustCompile(`cannot receive incremental stream: most recent snapshot of '.+' does not`)
 	BrokenPipe         = regexp.MustCompile(`broken pipe$`)
+	DatasetBusy        = regexp.MustCompile(`(pool or )?dataset is busy$`)
+	NotEnoughSpace     = regexp.MustCompile(`not enough space on '.+': need \d+ bytes, available \d+$`)
+	MountBusy          = regexp.MustCompile(`(?i)(target|device) is busy|device or resource busy`)
+	MountNotEmpty      = regexp.MustCompile(`directory is not empty`)
+	MountPermission    = regexp.MustCompile(`(?i)permission denied|insufficient privileges|may only be mounted by root`)
 
-	PoolError = errors.New("error creating clone: source and target in different pools")
+	PoolError       = errors.New("error creating clone: source and target in different pools")
+	RenamePoolError = errors.New("error renaming fs: source and target in different pools")
 )
 
 func joinErrs(errs []string) string {
@@ -32,8 +39,13 @@ func parseError(err error, stderr []byte) error {
 		return nil
 	}
 
+	switch err.(type) {
+	case EscalationError, TimeoutError:
+		return err
+	}
+
 	var errs []string
-	if stderr != nil {
+	if len(stderr) > 0 {
 		errs = strings.Split(string(stderr), "\n")
 	} else {
 		errs = strings.Split(string(err.Error()), "\n")
@@ -0,0 +1,190 @@
+package zfs
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/theairkit/runcmd"
+)
+
+// Stderr of escalation command which can't proceed without password
+var escalationDenied = regexp.MustCompile(
+	`(?i)(sudo|doas|pfexec): .*(password is required|terminal is required|` +
+		`authentication failed|not in the sudoers|not permitted)`,
+)
+
+// Wraps commands to run them with elevated privileges
+type Escalation struct {
+	command []string
+}
+
+var (
+	// Run commands as is
+	NoEscalation = Escalation{}
+
+	// Non-interactive sudo, fails with EscalationError instead of waiting
+	// for password
+	Sudo = Escalation{[]string{"sudo", "-n"}}
+
+	// Non-interactive doas
+	Doas = Escalation{[]string{"doas", "-n"}}
+
+	// Solaris and illumos profile shell
+	Pfexec = Escalation{[]string{"pfexec"}}
+)
+
+// Returns escalation running commands prefixed with given wrapper, like
+// 'sudo -u zfsadmin'
+func CustomEscalation(command ...string) Escalation {
+	return Escalation{command}
+}
+
+func escalationFor(sudo bool) Escalation {
+	if sudo {
+		return Sudo
+	}
+
+	return NoEscalation
+}
+
+func (e Escalation) IsNone() bool {
+	return len(e.command) == 0
+}
+
+func (e Escalation) String() string {
+	if e.IsNone() {
+		return "none"
+	}
+
+	return strings.Join(e.command, " ")
+}
+
+func (e Escalation) wrap(name string, args []string) (string, []string) {
+	if e.IsNone() {
+		return name, args
+	}
+
+	wrapped := append(append([]string{}, e.command[1:]...), name)
+	return e.command[0], append(wrapped, args...)
+}
+
+// Returned when escalation command refuses to run command without password
+// or permissions. Message matches NeedSudo.
+type EscalationError struct {
+	Escalation Escalation
+	Stderr     string
+}
+
+func (e EscalationError) Error() string {
+	return "need sudo: " + e.Escalation.String() + " failed: " + e.Stderr
+}
+
+// Sets escalation used for all commands
+func (z *ZfsRunner) SetEscalation(escalation Escalation) {
+	if z.mutex != nil {
+		z.mutex.Lock()
+		defer z.mutex.Unlock()
+	}
+
+	z.escalation = escalation
+}
+
+func (z *ZfsRunner) Escalation() Escalation {
+	return z.current().escalation
+}
+
+// Sets escalation of standard runner used by package level functions
+func SetStdEscalation(escalation Escalation) {
+	configureStd(func(z *ZfsRunner) {
+		z.escalation = escalation
+	})
+}
+
+// Turns escalation failures into EscalationError
+// Escalation command reports denial before running anything, so only
+// beginning of stderr is kept for started commands
+const escalationStderrLimit = 4096
+
+type escalatedWorker struct {
+	runcmd.CmdWorker
+	escalation Escalation
+
+	// Beginning of stderr of started command, teed from pipe returned to
+	// caller or read by worker itself if caller did not ask for it
+	stderr    bytes.Buffer
+	piped     bool
+	stderrEnd chan struct{}
+}
+
+func (w *escalatedWorker) Output() ([]byte, []byte, error) {
+	stdout, stderr, err := w.CmdWorker.Output()
+	return stdout, stderr, w.denied(err, stderr)
+}
+
+func (w *escalatedWorker) StderrPipe() (io.Reader, error) {
+	pipe, err := w.CmdWorker.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	w.piped = true
+	return io.TeeReader(pipe, limitedWriter{&w.stderr, escalationStderrLimit}), nil
+}
+
+func (w *escalatedWorker) Start() error {
+	if !w.piped {
+		// stderr may be already captured by runner, then error text is
+		// checked on Wait
+		if pipe, err := w.CmdWorker.StderrPipe(); err == nil {
+			w.stderrEnd = make(chan struct{})
+			go func() {
+				defer close(w.stderrEnd)
+				io.Copy(limitedWriter{&w.stderr, escalationStderrLimit}, pipe)
+			}()
+		}
+	}
+
+	return w.CmdWorker.Start()
+}
+
+func (w *escalatedWorker) Wait() error {
+	if w.stderrEnd != nil {
+		<-w.stderrEnd
+	}
+
+	err := w.CmdWorker.Wait()
+	if err != nil && w.stderr.Len() == 0 {
+		return w.denied(err, []byte(err.Error()))
+	}
+
+	return w.denied(err, w.stderr.Bytes())
+}
+
+func (w *escalatedWorker) denied(err error, stderr []byte) error {
+	if err != nil && escalationDenied.Match(stderr) {
+		return EscalationError{
+			w.escalation, strings.TrimSpace(string(stderr)),
+		}
+	}
+
+	return err
+}
+
+// Keeps first limit bytes and discards the rest without failing writes
+type limitedWriter struct {
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (w limitedWriter) Write(data []byte) (int, error) {
+	if free := w.limit - w.buffer.Len(); free > 0 {
+		if len(data) < free {
+			free = len(data)
+		}
+		w.buffer.Write(data[:free])
+	}
+
+	return len(data), nil
+}
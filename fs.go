@@ -2,7 +2,6 @@ package zfs
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -25,9 +24,11 @@ type ZfsEntry interface {
 	GetPool() string
 	GetLastPath() string
 	Destroy(RecursiveFlag) error
+	DestroyWithOptions(DestroyOptions) (DestroyPlan, error)
 	Exists() (bool, error)
 	Receive() (runcmd.CmdWorker, io.WriteCloser, error)
 	getPath() string
+	getRunner() Zfs
 }
 
 type zfsEntryBase struct {
@@ -36,6 +37,14 @@ type zfsEntryBase struct {
 }
 
 func (z zfsEntryBase) GetProperty(prop string) (string, error) {
+	if z.runner.useJSON() {
+		properties, err := z.getJSON(prop)
+		if err != nil {
+			return "", err
+		}
+		return properties[prop].Value, nil
+	}
+
 	c := z.runner.Command("zfs", "get", "-Hp", "-o", "value", prop, z.Path)
 
 	stdout, stderr, err := c.Output()
@@ -45,14 +54,85 @@ func (z zfsEntryBase) GetProperty(prop string) (string, error) {
 	return strings.Split(string(stdout), "\n")[0], nil
 }
 
-func (z zfsEntryBase) GetPropertyInt(prop string) (int64, error) {
-	c := z.runner.Command("zfs", "get", "-Hp", "-o", "value", prop, z.Path)
+// Runs zfs get with JSON output, checks that all properties are returned
+func (z zfsEntryBase) getJSON(props ...string) (map[string]Property, error) {
+	c := z.runner.Command(
+		"zfs", "get", "-jp", strings.Join(props, ","), z.Path,
+	)
 
 	stdout, stderr, err := c.Output()
 	if err != nil {
-		return 0, parseError(err, stderr)
+		return nil, parseError(err, stderr)
+	}
+
+	properties, err := parseJSONProperties(stdout, z.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prop := range props {
+		if _, ok := properties[prop]; !ok {
+			return properties, errors.New("property " + prop + " not found")
+		}
+	}
+
+	return properties, nil
+}
+
+// Property value with its source: local, default, inherited from ...
+type Property struct {
+	Value  string
+	Source string
+}
+
+// Returns several properties with one zfs get call
+func (z zfsEntryBase) GetProperties(
+	props ...string,
+) (map[string]Property, error) {
+	if z.runner.useJSON() {
+		return z.getJSON(props...)
+	}
+
+	c := z.runner.Command(
+		"zfs", "get", "-Hp", "-o", "property,value,source",
+		strings.Join(props, ","), z.Path,
+	)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return nil, parseError(err, stderr)
+	}
+
+	properties := map[string]Property{}
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		properties[fields[0]] = Property{fields[1], fields[2]}
+	}
+
+	for _, prop := range props {
+		if _, ok := properties[prop]; !ok {
+			return properties, errors.New("property " + prop + " not found")
+		}
+	}
+
+	return properties, nil
+}
+
+func (z zfsEntryBase) GetPropertyInt(prop string) (int64, error) {
+	value, err := z.GetProperty(prop)
+	if err != nil {
+		return 0, err
 	}
-	val, err := strconv.ParseInt(strings.Split(string(stdout), "\n")[0], 10, 64)
+
+	// sizes like quota are printed as none even with -p
+	if value == "none" {
+		return 0, nil
+	}
+
+	val, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
 		return 0, errors.New("error converting to int: " + err.Error())
 	}
@@ -63,38 +143,33 @@ func (z zfsEntryBase) getPath() string {
 	return z.Path
 }
 
+func (z zfsEntryBase) getRunner() Zfs {
+	return z.runner
+}
+
 func (z zfsEntryBase) SetProperty(prop, value string) error {
 	c := z.runner.Command("zfs", "set", prop+"="+value, z.Path)
 
 	if _, stderr, err := c.Output(); err != nil {
 		return parseError(err, stderr)
 	}
+	if z.runner.IsDryRun() {
+		return nil
+	}
+
 	out, err := z.GetProperty(prop)
 	if err != nil {
 		return err
 	}
-	if out != value {
+	if out != value && !sameSize(out, value) {
 		return errors.New("property " + prop + " not set")
 	}
 	return nil
 }
 
 func (z zfsEntryBase) Destroy(recursive RecursiveFlag) error {
-	args := []string{"destroy"}
-
-	switch recursive {
-	case RF_Soft:
-		args = append(args, "-r")
-	case RF_Hard:
-		args = append(args, "-R")
-	}
-
-	args = append(args, z.Path)
-
-	c := z.runner.Command("zfs", args...)
-
-	_, stderr, err := c.Output()
-	return parseError(err, stderr)
+	_, err := z.DestroyWithOptions(DestroyOptions{Recursive: recursive})
+	return err
 }
 
 func (z zfsEntryBase) Exists() (bool, error) {
@@ -123,6 +198,9 @@ func (z zfsEntryBase) Receive() (runcmd.CmdWorker, io.WriteCloser, error) {
 	}
 
 	c = z.runner.Command("zfs", "receive", "-F", z.Path)
+	if z.runner.IsDryRun() {
+		return c, discardCloser{io.Discard}, c.Start()
+	}
 
 	stdinPipe, err := c.StdinPipe()
 	if err != nil {
@@ -133,13 +211,11 @@ func (z zfsEntryBase) Receive() (runcmd.CmdWorker, io.WriteCloser, error) {
 }
 
 func (z zfsEntryBase) GetPool() string {
-	buf := strings.SplitN(z.Path, "/", 2)
-	return buf[0]
+	return PoolName(z.Path)
 }
 
 func (z zfsEntryBase) GetLastPath() string {
-	buf := strings.Split(z.Path, "/")
-	return buf[len(buf)-1]
+	return LastPathComponent(z.Path)
 }
 
 type Fs struct {
@@ -148,29 +224,17 @@ type Fs struct {
 
 // See Zfs.CreateFs
 func CreateFs(zfsPath string) (Fs, error) {
-	return std.CreateFs(zfsPath)
+	return std().CreateFs(zfsPath)
 }
 
-// Actually creates filesystem
+// Actually creates filesystem with all missing parents
 func (z Zfs) CreateFs(zfsPath string) (Fs, error) {
-	fs := NewFs(zfsPath)
-	ok, err := fs.Exists()
-	if err != nil {
-		return z.NewFs(zfsPath), err
-	}
-	if ok {
-		return z.NewFs(zfsPath), errors.New(fmt.Sprintf("fs %s already exists", zfsPath))
-	}
-
-	c := z.Command("zfs", "create", "-p", zfsPath)
-
-	_, stderr, err := c.Output()
-	return z.NewFs(zfsPath), parseError(err, stderr)
+	return z.CreateFsWithOptions(zfsPath, CreateOptions{Parents: true})
 }
 
 // See Zfs.NewFs
 func NewFs(zfsPath string) Fs {
-	return std.NewFs(zfsPath)
+	return std().NewFs(zfsPath)
 }
 
 // Return Fs wrapper without any checks and actualy creation
@@ -180,11 +244,26 @@ func (z Zfs) NewFs(zfsPath string) Fs {
 
 // See Zfs.ListFs
 func ListFs(path string) ([]Fs, error) {
-	return std.ListFs(path)
+	return std().ListFs(path)
 }
 
 // Return list of all found filesystems
 func (z Zfs) ListFs(path string) ([]Fs, error) {
+	if z.useJSON() {
+		datasets, err := z.List(
+			ListOptions{Paths: []string{path}, Recursive: true},
+		)
+		if err != nil {
+			return []Fs{}, err
+		}
+
+		filesystems := []Fs{}
+		for _, dataset := range datasets {
+			filesystems = append(filesystems, z.NewFs(dataset.getPath()))
+		}
+		return filesystems, nil
+	}
+
 	c := z.Command("zfs", "list", "-Hr", "-o", "name", path)
 
 	stdout, stderr, err := c.Output()
@@ -215,16 +294,22 @@ func (f Fs) Promote() error {
 	return parseError(err, stderr)
 }
 
-func (f Fs) Mount() error {
-	c := f.runner.Command("zfs", "mount", f.Path)
+// Renames filesystem within its pool, creating missing parents
+func (f Fs) Rename(newPath string) (Fs, error) {
+	if err := checkDatasetName(newPath); err != nil {
+		return Fs{}, err
+	}
 
-	_, stderr, err := c.Output()
-	return parseError(err, stderr)
-}
+	if f.GetPool() != PoolName(newPath) {
+		return Fs{}, RenamePoolError
+	}
 
-func (f Fs) Unmount() error {
-	c := f.runner.Command("zfs", "unmount", f.Path)
+	c := f.runner.Command("zfs", "rename", "-p", f.Path, newPath)
 
 	_, stderr, err := c.Output()
-	return parseError(err, stderr)
+	if err != nil {
+		return Fs{}, parseError(err, stderr)
+	}
+
+	return f.runner.NewFs(newPath), nil
 }
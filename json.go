@@ -0,0 +1,238 @@
+package zfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Output format of zfs and zpool commands
+type OutputFormat int
+
+const (
+	// JSON if zfs supports it (OpenZFS 2.3+), text otherwise
+	OutputAuto OutputFormat = iota
+	OutputText
+	OutputJSON
+)
+
+// Selects output format used by listing and property functions
+func WithOutputFormat(format OutputFormat) Option {
+	return func(z *ZfsRunner) error {
+		z.format = format
+		return nil
+	}
+}
+
+// Returns true if commands should be run with -j. Only zfs version is
+// checked, decision is cached per runner, so version detection errors mean
+// text output.
+func (z Zfs) useJSON() bool {
+	switch z.format {
+	case OutputText:
+		return false
+	case OutputJSON:
+		return true
+	}
+
+	cache := z.capabilities
+	if cache == nil {
+		return false
+	}
+
+	cache.mutex.Lock()
+	json := cache.json
+	cache.mutex.Unlock()
+
+	if json != nil {
+		return *json
+	}
+
+	version, err := z.Version()
+	supported := err == nil &&
+		Capabilities{Version: version}.Supports(CapJSON, "")
+
+	cache.mutex.Lock()
+	cache.json = &supported
+	cache.mutex.Unlock()
+
+	return supported
+}
+
+// Property value which is string with -p, but number with --json-int
+type jsonValue string
+
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*v = jsonValue(value)
+		return nil
+	}
+
+	*v = jsonValue(data)
+	return nil
+}
+
+type jsonProperty struct {
+	Value  jsonValue `json:"value"`
+	Source struct {
+		Type string `json:"type"`
+		Data string `json:"data"`
+	} `json:"source"`
+}
+
+// Returns property in the same form as text output of zfs get
+func (p jsonProperty) property() Property {
+	source := "-"
+	switch p.Source.Type {
+	case "LOCAL":
+		source = "local"
+	case "DEFAULT":
+		source = "default"
+	case "INHERITED":
+		source = "inherited from " + p.Source.Data
+	case "TEMPORARY":
+		source = "temporary"
+	case "RECEIVED":
+		source = "received"
+	}
+
+	return Property{string(p.Value), source}
+}
+
+// Dataset or pool in JSON output
+type jsonObject struct {
+	Name       string                  `json:"name"`
+	Type       string                  `json:"type"`
+	Properties map[string]jsonProperty `json:"properties"`
+}
+
+// Returns property value, name and type are also accepted as properties
+// like in text output
+func (o jsonObject) property(prop string) string {
+	switch prop {
+	case "name":
+		return o.Name
+	case "type":
+		return strings.ToLower(o.Type)
+	}
+
+	if value, ok := o.Properties[prop]; ok {
+		return string(value.Value)
+	}
+
+	return "-"
+}
+
+// Decodes objects from given section of JSON output, like datasets or
+// pools, keeping order in which zfs printed them
+func decodeJSONObjects(output []byte, section string) ([]jsonObject, error) {
+	objects := []jsonObject{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+
+	err := decodeJSONMembers(decoder, func(key string) error {
+		if key != section {
+			var skip json.RawMessage
+			return decoder.Decode(&skip)
+		}
+
+		return decodeJSONMembers(decoder, func(string) error {
+			object := jsonObject{}
+			if err := decoder.Decode(&object); err != nil {
+				return err
+			}
+			objects = append(objects, object)
+			return nil
+		})
+	})
+	if err != nil {
+		return objects, errors.New("error parsing zfs json output: " + err.Error())
+	}
+
+	return objects, nil
+}
+
+// Calls decodeValue for every member of JSON object in document order,
+// decodeValue should consume member value from decoder
+func decodeJSONMembers(
+	decoder *json.Decoder, decodeValue func(key string) error,
+) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return errors.New("object expected")
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		key, ok := token.(string)
+		if !ok {
+			return errors.New("object key expected")
+		}
+
+		if err := decodeValue(key); err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
+
+func (z Zfs) parseJSONList(output []byte, props []string) ([]Dataset, error) {
+	objects, err := decodeJSONObjects(output, "datasets")
+	if err != nil {
+		return []Dataset{}, err
+	}
+
+	datasets := []Dataset{}
+	for _, object := range objects {
+		datasetType := DatasetType(object.property("type"))
+		dataset := Dataset{
+			ZfsEntry:   z.newEntry(object.Name, datasetType),
+			Type:       datasetType,
+			Properties: map[string]string{},
+		}
+		for _, prop := range props {
+			dataset.Properties[prop] = object.property(prop)
+		}
+
+		datasets = append(datasets, dataset)
+	}
+
+	return datasets, nil
+}
+
+// Returns properties of given dataset from 'zfs get -j' output
+func parseJSONProperties(
+	output []byte, path string,
+) (map[string]Property, error) {
+	objects, err := decodeJSONObjects(output, "datasets")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		if object.Name != path {
+			continue
+		}
+
+		properties := map[string]Property{}
+		for prop, value := range object.Properties {
+			properties[prop] = value.property()
+		}
+		return properties, nil
+	}
+
+	return nil, errors.New("dataset " + path + " not found in zfs get output")
+}
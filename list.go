@@ -0,0 +1,175 @@
+package zfs
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type DatasetType string
+
+const (
+	TypeFilesystem DatasetType = "filesystem"
+	TypeVolume     DatasetType = "volume"
+	TypeSnapshot   DatasetType = "snapshot"
+	TypeBookmark   DatasetType = "bookmark"
+	TypeAll        DatasetType = "all"
+)
+
+type ListOptions struct {
+	// Datasets to list, all datasets are listed if empty
+	Paths []string
+
+	// Dataset types (-t), filesystems and volumes are listed if empty
+	Types []DatasetType
+
+	// List all descendents (-r)
+	Recursive bool
+
+	// Limit recursion depth (-d), implies Recursive
+	Depth int
+
+	// Properties to sort by in ascending (-s) and descending (-S) order
+	SortAsc  []string
+	SortDesc []string
+
+	// Properties to fetch along with dataset names
+	Properties []string
+}
+
+func (o ListOptions) args() []string {
+	args := []string{"list", "-Hp"}
+
+	if o.Depth > 0 {
+		args = append(args, "-d", strconv.Itoa(o.Depth))
+	} else if o.Recursive {
+		args = append(args, "-r")
+	}
+
+	if len(o.Types) > 0 {
+		types := []string{}
+		for _, t := range o.Types {
+			types = append(types, string(t))
+		}
+		args = append(args, "-t", strings.Join(types, ","))
+	}
+
+	for _, prop := range o.SortAsc {
+		args = append(args, "-s", prop)
+	}
+	for _, prop := range o.SortDesc {
+		args = append(args, "-S", prop)
+	}
+
+	columns := append([]string{"name", "type"}, o.Properties...)
+	args = append(args, "-o", strings.Join(columns, ","))
+
+	return append(args, o.Paths...)
+}
+
+// Dataset returned by List with properties fetched in the same call.
+// Entry is Fs, Volume, Snapshot or Bookmark depending on Type.
+type Dataset struct {
+	ZfsEntry
+	Type       DatasetType
+	Properties map[string]string
+}
+
+// Returns property value fetched by List
+func (d Dataset) Property(prop string) (string, bool) {
+	value, ok := d.Properties[prop]
+	return value, ok
+}
+
+// Returns property value fetched by List converted to int
+func (d Dataset) PropertyInt(prop string) (int64, error) {
+	value, ok := d.Properties[prop]
+	if !ok {
+		return 0, errors.New("property " + prop + " not listed")
+	}
+
+	val, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("error converting to int: " + err.Error())
+	}
+	return val, nil
+}
+
+// See Zfs.List
+func List(opts ListOptions) ([]Dataset, error) {
+	return std().List(opts)
+}
+
+// Lists datasets with requested properties in one zfs list call
+func (z Zfs) List(opts ListOptions) ([]Dataset, error) {
+	datasets, err := z.list(opts)
+	if err != nil && NotExist.MatchString(err.Error()) {
+		return []Dataset{}, nil
+	}
+
+	return datasets, err
+}
+
+// Runs zfs list with JSON output if it is supported
+func (z Zfs) list(opts ListOptions) ([]Dataset, error) {
+	json := z.useJSON()
+
+	args := opts.args()
+	if json {
+		args[1] = "-jp"
+	}
+
+	c := z.Command("zfs", args...)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return []Dataset{}, parseError(err, stderr)
+	}
+
+	if json {
+		return z.parseJSONList(stdout, opts.Properties)
+	}
+
+	return z.parseList(string(stdout), opts.Properties)
+}
+
+func (z Zfs) parseList(output string, props []string) ([]Dataset, error) {
+	datasets := []Dataset{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(props)+2 {
+			return datasets, errors.New("unexpected zfs list output: " + line)
+		}
+
+		dataset := Dataset{
+			ZfsEntry:   z.newEntry(fields[0], DatasetType(fields[1])),
+			Type:       DatasetType(fields[1]),
+			Properties: map[string]string{},
+		}
+		for i, prop := range props {
+			dataset.Properties[prop] = fields[i+2]
+		}
+
+		datasets = append(datasets, dataset)
+	}
+
+	return datasets, nil
+}
+
+// Returns wrapper of type matching dataset type
+func (z Zfs) newEntry(path string, datasetType DatasetType) ZfsEntry {
+	switch datasetType {
+	case TypeVolume:
+		return z.NewVolume(path)
+	case TypeSnapshot:
+		return z.newSnapshot(path)
+	case TypeBookmark:
+		return z.newBookmark(path)
+	default:
+		return z.NewFs(path)
+	}
+}
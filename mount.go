@@ -0,0 +1,177 @@
+package zfs
+
+import "strings"
+
+type MountOptions struct {
+	// Temporary mount options (-o), like ro or nosuid
+	Options []string
+
+	// Allow mounting over non-empty directory (-O)
+	Overlay bool
+
+	// Load encryption keys before mounting (-l)
+	LoadKeys bool
+}
+
+func (o MountOptions) args() []string {
+	args := []string{"mount"}
+
+	if len(o.Options) > 0 {
+		args = append(args, "-o", strings.Join(o.Options, ","))
+	}
+	if o.Overlay {
+		args = append(args, "-O")
+	}
+	if o.LoadKeys {
+		args = append(args, "-l")
+	}
+
+	return args
+}
+
+type MountFailure int
+
+const (
+	MountFailed MountFailure = iota
+	MountFailedBusy
+	MountFailedNotEmpty
+	MountFailedPermission
+)
+
+// Returned by mount and unmount calls
+type MountError struct {
+	Path   string
+	Reason MountFailure
+	Err    error
+}
+
+func (e MountError) Error() string {
+	return e.Err.Error()
+}
+
+func (e MountError) Unwrap() error {
+	return e.Err
+}
+
+func mountError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	reason := MountFailed
+	switch {
+	case MountBusy.MatchString(err.Error()):
+		reason = MountFailedBusy
+	case MountNotEmpty.MatchString(err.Error()):
+		reason = MountFailedNotEmpty
+	case MountPermission.MatchString(err.Error()):
+		reason = MountFailedPermission
+	}
+
+	return MountError{path, reason, err}
+}
+
+func (f Fs) Mount(opts MountOptions) error {
+	c := f.runner.Command("zfs", append(opts.args(), f.Path)...)
+
+	_, stderr, err := c.Output()
+	return mountError(f.Path, parseError(err, stderr))
+}
+
+func (f Fs) Unmount(force bool) error {
+	args := []string{"unmount"}
+	if force {
+		args = append(args, "-f")
+	}
+
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	_, stderr, err := c.Output()
+	return mountError(f.Path, parseError(err, stderr))
+}
+
+// See Zfs.MountAll
+func MountAll(opts MountOptions) error {
+	return std().MountAll(opts)
+}
+
+// Mounts all filesystems with canmount=on
+func (z Zfs) MountAll(opts MountOptions) error {
+	c := z.Command("zfs", append(opts.args(), "-a")...)
+
+	_, stderr, err := c.Output()
+	return mountError("", parseError(err, stderr))
+}
+
+// See Zfs.UnmountAll
+func UnmountAll(force bool) error {
+	return std().UnmountAll(force)
+}
+
+// Unmounts all currently mounted filesystems
+func (z Zfs) UnmountAll(force bool) error {
+	args := []string{"unmount", "-a"}
+	if force {
+		args = append(args, "-f")
+	}
+
+	c := z.Command("zfs", args...)
+
+	_, stderr, err := c.Output()
+	return mountError("", parseError(err, stderr))
+}
+
+func (f Fs) IsMounted() (bool, error) {
+	mounted, err := f.GetProperty("mounted")
+	if err != nil {
+		return false, err
+	}
+
+	return mounted == "yes", nil
+}
+
+// Resolved mount settings of filesystem
+type Mountpoint struct {
+	// Directory filesystem is mounted to, empty for legacy and none
+	Path string
+
+	// Source of mountpoint property: local, default or inherited from ...
+	Source string
+
+	// Filesystem is mounted by mount(8) and fstab
+	Legacy bool
+
+	// Value of canmount property: on, off or noauto
+	CanMount string
+
+	Mounted bool
+}
+
+// Returns true if filesystem is mounted by zfs mount -a
+func (m Mountpoint) AutoMount() bool {
+	return m.Path != "" && m.CanMount == "on"
+}
+
+func (f Fs) Mountpoint() (Mountpoint, error) {
+	props, err := f.GetProperties("mountpoint", "canmount", "mounted")
+	if err != nil {
+		return Mountpoint{}, err
+	}
+
+	mountpoint := Mountpoint{
+		Path:     props["mountpoint"].Value,
+		Source:   props["mountpoint"].Source,
+		CanMount: props["canmount"].Value,
+		Mounted:  props["mounted"].Value == "yes",
+	}
+
+	switch mountpoint.Path {
+	case "legacy":
+		mountpoint.Path = ""
+		mountpoint.Legacy = true
+	case "none", "-":
+		mountpoint.Path = ""
+	}
+
+	return mountpoint, nil
+}
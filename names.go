@@ -0,0 +1,181 @@
+package zfs
+
+import "strings"
+
+const (
+	// zfs limit for full dataset name, including snapshot part
+	maxDatasetNameLen = 256
+
+	// limit of component length, components are used as mountpoint
+	// directory names
+	maxComponentLen = 255
+)
+
+// Pool names reserved by zpool, pool can't be named with these prefixes
+var reservedPoolPrefixes = []string{"mirror", "raidz", "draid", "spare"}
+
+// Parsed dataset, snapshot or bookmark name
+type DatasetName struct {
+	Pool       string
+	Components []string
+	Snapshot   string
+	Bookmark   string
+}
+
+// Returns path of filesystem or volume, without snapshot or bookmark part
+func (n DatasetName) Dataset() string {
+	return strings.Join(append([]string{n.Pool}, n.Components...), "/")
+}
+
+func (n DatasetName) String() string {
+	switch {
+	case n.Snapshot != "":
+		return n.Dataset() + "@" + n.Snapshot
+	case n.Bookmark != "":
+		return n.Dataset() + "#" + n.Bookmark
+	default:
+		return n.Dataset()
+	}
+}
+
+func (n DatasetName) IsSnapshot() bool {
+	return n.Snapshot != ""
+}
+
+func (n DatasetName) IsBookmark() bool {
+	return n.Bookmark != ""
+}
+
+// Returned for names rejected by ParseName, matches InvalidDataset
+type NameError struct {
+	Name   string
+	Reason string
+}
+
+func (e NameError) Error() string {
+	return "cannot use '" + e.Name + "': " + e.Reason + ": invalid dataset name"
+}
+
+// Parses and validates dataset name using zfs naming rules, without
+// calling zfs
+func ParseName(name string) (DatasetName, error) {
+	result := DatasetName{}
+	fail := func(reason string) (DatasetName, error) {
+		return DatasetName{}, NameError{name, reason}
+	}
+
+	switch {
+	case name == "":
+		return fail("empty name")
+	case len(name) >= maxDatasetNameLen:
+		return fail("name is too long")
+	case strings.HasPrefix(name, "/"):
+		return fail("leading slash in name")
+	case strings.Count(name, "@")+strings.Count(name, "#") > 1:
+		return fail("multiple '@' and/or '#' delimiters in name")
+	}
+
+	path := name
+	if fs, snap, ok := splitSnapshotPath(name); ok {
+		path, result.Snapshot = fs, snap
+		if snap == "" {
+			return fail("empty snapshot name")
+		}
+		if reason := checkComponent(snap); reason != "" {
+			return fail(reason + " in snapshot name")
+		}
+	}
+	if fs, mark, ok := splitBookmarkPath(name); ok {
+		path, result.Bookmark = fs, mark
+		if mark == "" {
+			return fail("empty bookmark name")
+		}
+		if reason := checkComponent(mark); reason != "" {
+			return fail(reason + " in bookmark name")
+		}
+	}
+
+	if strings.HasSuffix(path, "/") {
+		return fail("trailing slash in name")
+	}
+
+	components := strings.Split(path, "/")
+	for _, component := range components {
+		if reason := checkComponent(component); reason != "" {
+			return fail(reason)
+		}
+	}
+
+	if reason := checkPoolName(components[0]); reason != "" {
+		return fail(reason)
+	}
+
+	result.Pool = components[0]
+	result.Components = components[1:]
+
+	return result, nil
+}
+
+// Checks that name is valid filesystem or volume name
+func checkDatasetName(name string) error {
+	parsed, err := ParseName(name)
+	if err != nil {
+		return err
+	}
+
+	if parsed.IsSnapshot() || parsed.IsBookmark() {
+		return NameError{name, "snapshot or bookmark name given for dataset"}
+	}
+
+	return nil
+}
+
+// Returns reason why component is invalid, empty string for valid one
+func checkComponent(component string) string {
+	switch {
+	case component == "":
+		return "empty component"
+	case component == "." || component == "..":
+		return "self or parent reference"
+	case len(component) > maxComponentLen:
+		return "component is too long"
+	}
+
+	for _, c := range component {
+		if !validNameChar(c) {
+			return "invalid character '" + string(c) + "'"
+		}
+	}
+
+	return ""
+}
+
+func checkPoolName(pool string) string {
+	first := pool[0]
+	if !(first >= 'a' && first <= 'z' || first >= 'A' && first <= 'Z') {
+		return "pool name must begin with a letter"
+	}
+
+	if pool == "log" {
+		return "pool name is reserved"
+	}
+	for _, prefix := range reservedPoolPrefixes {
+		if strings.HasPrefix(pool, prefix) {
+			return "pool name is reserved"
+		}
+	}
+
+	// c0, c1... are reserved for solaris disk names
+	if len(pool) > 1 && pool[0] == 'c' && pool[1] >= '0' && pool[1] <= '9' {
+		return "pool name is reserved"
+	}
+
+	return ""
+}
+
+func validNameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' ||
+		c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' ||
+		strings.ContainsRune("-_.: ", c)
+}
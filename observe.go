@@ -0,0 +1,244 @@
+package zfs
+
+import (
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/theairkit/runcmd"
+)
+
+type RunnerKind string
+
+const (
+	RunnerLocal  RunnerKind = "local"
+	RunnerRemote RunnerKind = "remote"
+)
+
+// Max length of stderr passed to Observer
+const maxObservedStderr = 1024
+
+var exitStatus = regexp.MustCompile(`exit status (\d+)`)
+
+// Describes command executed by ZfsRunner
+type CommandEvent struct {
+	// Command with arguments, including escalation wrapper, redacted
+	Args   []string
+	Runner RunnerKind
+	Start  time.Time
+
+	// Fields below are set only for AfterCommand
+	Duration time.Duration
+
+	// Process exit status, -1 if command failed without exit status
+	ExitStatus int
+	Err        error
+
+	// Command stderr truncated to 1024 bytes, empty for streaming commands
+	Stderr string
+}
+
+// Called before and after every command executed by ZfsRunner
+type Observer interface {
+	BeforeCommand(CommandEvent)
+	AfterCommand(CommandEvent)
+}
+
+// Rewrites command arguments before they are passed to Observer
+type Redactor func(args []string) []string
+
+// Returns Redactor replacing parts of arguments matching pattern with
+// replacement, which may refer to submatches like regexp.ReplaceAllString
+func RedactPattern(pattern *regexp.Regexp, replacement string) Redactor {
+	return func(args []string) []string {
+		redacted := make([]string, len(args))
+		for i, arg := range args {
+			redacted[i] = pattern.ReplaceAllString(arg, replacement)
+		}
+		return redacted
+	}
+}
+
+// Hides values of properties which names look like secrets
+var RedactSecretProps = RedactPattern(
+	regexp.MustCompile(`(?i)^([^=]*(?:key|pass|secret|token)[^=]*=).+$`),
+	"${1}***",
+)
+
+// Hides share options, which may contain hosts, users and credentials
+var RedactShareOptions = RedactPattern(
+	regexp.MustCompile(`^((?:sharenfs|sharesmb)=).+$`),
+	"${1}***",
+)
+
+// Applied before redactors given to SetObserver, so observers never see
+// secrets and share options
+var defaultRedactors = []Redactor{RedactSecretProps, RedactShareOptions}
+
+// Sets observer for all commands executed by runner. Secret properties and
+// share options are always redacted, then redactors are applied to command
+// arguments in given order.
+func (z *ZfsRunner) SetObserver(observer Observer, redactors ...Redactor) {
+	if z.mutex != nil {
+		z.mutex.Lock()
+		defer z.mutex.Unlock()
+	}
+
+	z.observer = observer
+	z.redactors = redactors
+}
+
+// Sets observer of standard runner used by package level functions
+func SetStdObserver(observer Observer, redactors ...Redactor) {
+	configureStd(func(z *ZfsRunner) {
+		z.observer = observer
+		z.redactors = redactors
+	})
+}
+
+func (z ZfsRunner) observe(
+	worker runcmd.CmdWorker, name string, args []string,
+) runcmd.CmdWorker {
+	if z.observer == nil {
+		return worker
+	}
+
+	return &observedWorker{
+		CmdWorker: worker,
+		observer:  z.observer,
+		event: CommandEvent{
+			Args:   z.redact(append([]string{name}, args...)),
+			Runner: z.kind,
+		},
+	}
+}
+
+func (z ZfsRunner) redact(argv []string) []string {
+	for _, redactor := range defaultRedactors {
+		argv = redactor(argv)
+	}
+	for _, redactor := range z.redactors {
+		argv = redactor(argv)
+	}
+
+	return argv
+}
+
+// Optional Runner extension for runners wrapping remote ones, like
+// connection pools, which kind can't be detected by type
+type KindRunner interface {
+	RunnerKind() RunnerKind
+}
+
+func runnerKind(runner runcmd.Runner) RunnerKind {
+	switch runner := runner.(type) {
+	case KindRunner:
+		return runner.RunnerKind()
+	case *runcmd.Remote:
+		return RunnerRemote
+	default:
+		return RunnerLocal
+	}
+}
+
+// Reports command execution to observer
+type observedWorker struct {
+	runcmd.CmdWorker
+	observer Observer
+	event    CommandEvent
+}
+
+func (w *observedWorker) before() {
+	w.event.Start = time.Now()
+	w.observer.BeforeCommand(w.event)
+}
+
+func (w *observedWorker) after(err error, stderr []byte) {
+	event := w.event
+	event.Duration = time.Since(event.Start)
+	event.Err = err
+
+	if len(stderr) > maxObservedStderr {
+		stderr = stderr[:maxObservedStderr]
+	}
+	event.Stderr = string(stderr)
+
+	switch {
+	case err == nil:
+		event.ExitStatus = 0
+	case exitStatus.MatchString(err.Error()):
+		status := exitStatus.FindStringSubmatch(err.Error())[1]
+		event.ExitStatus, _ = strconv.Atoi(status)
+	default:
+		event.ExitStatus = -1
+	}
+
+	w.observer.AfterCommand(event)
+}
+
+func (w *observedWorker) Output() ([]byte, []byte, error) {
+	w.before()
+	stdout, stderr, err := w.CmdWorker.Output()
+	w.after(err, stderr)
+
+	return stdout, stderr, err
+}
+
+func (w *observedWorker) Start() error {
+	w.before()
+	err := w.CmdWorker.Start()
+	if err != nil {
+		w.after(err, nil)
+	}
+
+	return err
+}
+
+func (w *observedWorker) Wait() error {
+	err := w.CmdWorker.Wait()
+	w.after(err, nil)
+
+	return err
+}
+
+// Observer logging commands to slog logger: start at debug level, success
+// at info and failures at error level
+type SlogObserver struct {
+	Logger *slog.Logger
+}
+
+func (o SlogObserver) BeforeCommand(event CommandEvent) {
+	o.Logger.Debug(
+		"running zfs command",
+		"args", event.Args,
+		"runner", string(event.Runner),
+	)
+}
+
+func (o SlogObserver) AfterCommand(event CommandEvent) {
+	attrs := []any{
+		"args", event.Args,
+		"runner", string(event.Runner),
+		"duration", event.Duration,
+		"exit_status", event.ExitStatus,
+	}
+
+	if event.Err == nil {
+		o.Logger.Info("zfs command finished", attrs...)
+		return
+	}
+
+	attrs = append(attrs, "error", event.Err.Error(), "stderr", event.Stderr)
+	o.Logger.Error("zfs command failed", attrs...)
+}
+
+func (o SlogObserver) RetryCommand(event RetryEvent) {
+	o.Logger.Warn(
+		"retrying zfs command",
+		"args", event.Args,
+		"attempt", event.Attempt,
+		"delay", event.Delay,
+		"error", event.Err.Error(),
+	)
+}
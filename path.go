@@ -0,0 +1,73 @@
+package zfs
+
+import "strings"
+
+// Joins dataset path with given elements. Elements may contain several
+// components separated by slash, result is validated with ParseName.
+func JoinPath(base string, elems ...string) (string, error) {
+	parts := []string{base}
+	for _, elem := range elems {
+		for _, component := range strings.Split(elem, "/") {
+			if reason := checkComponent(component); reason != "" {
+				return "", NameError{elem, reason}
+			}
+		}
+		parts = append(parts, elem)
+	}
+
+	path := strings.Join(parts, "/")
+	if _, err := ParseName(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Returns path of parent dataset. Parent of snapshot or bookmark is its
+// filesystem, pool has no parent.
+func ParentPath(path string) (string, bool) {
+	if fs, _, ok := splitSnapshotPath(path); ok {
+		return fs, true
+	}
+	if fs, _, ok := splitBookmarkPath(path); ok {
+		return fs, true
+	}
+
+	slash := strings.LastIndex(path, "/")
+	if slash < 0 {
+		return "", false
+	}
+
+	return path[:slash], true
+}
+
+// Returns pool name of dataset path
+func PoolName(path string) string {
+	if i := strings.IndexAny(path, "/@#"); i >= 0 {
+		return path[:i]
+	}
+
+	return path
+}
+
+// Returns last component of dataset path
+func LastPathComponent(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func splitSnapshotPath(path string) (string, string, bool) {
+	return splitPath(path, "@")
+}
+
+func splitBookmarkPath(path string) (string, string, bool) {
+	return splitPath(path, "#")
+}
+
+func splitPath(path, delimiter string) (string, string, bool) {
+	i := strings.Index(path, delimiter)
+	if i < 0 {
+		return path, "", false
+	}
+
+	return path[:i], path[i+1:], true
+}
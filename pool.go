@@ -0,0 +1,354 @@
+package zfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Pool struct {
+	Name string
+
+	// ONLINE, DEGRADED, FAULTED, OFFLINE, REMOVED or UNAVAIL
+	Health string
+
+	// Properties requested in ListPools
+	Properties map[string]string
+}
+
+// See Zfs.ListPools
+func ListPools(props ...string) ([]Pool, error) {
+	return std().ListPools(props...)
+}
+
+// Lists imported pools with given properties
+func (z Zfs) ListPools(props ...string) ([]Pool, error) {
+	json := z.useJSON()
+
+	format := "-Hp"
+	if json {
+		format = "-jp"
+	}
+
+	columns := append([]string{"name", "health"}, props...)
+	c := z.Command("zpool", "list", format, "-o", strings.Join(columns, ","))
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return []Pool{}, parseError(err, stderr)
+	}
+
+	if json {
+		return parseJSONPools(stdout, props)
+	}
+
+	return parsePools(string(stdout), props)
+}
+
+func parsePools(output string, props []string) ([]Pool, error) {
+	pools := []Pool{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(props)+2 {
+			return pools, errors.New("unexpected zpool list output: " + line)
+		}
+
+		pool := Pool{fields[0], fields[1], map[string]string{}}
+		for i, prop := range props {
+			pool.Properties[prop] = fields[i+2]
+		}
+
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+func parseJSONPools(output []byte, props []string) ([]Pool, error) {
+	objects, err := decodeJSONObjects(output, "pools")
+	if err != nil {
+		return []Pool{}, err
+	}
+
+	pools := []Pool{}
+	for _, object := range objects {
+		pool := Pool{object.Name, object.property("health"), map[string]string{}}
+		for _, prop := range props {
+			pool.Properties[prop] = object.property(prop)
+		}
+
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// Device of pool configuration as shown by zpool status
+type Vdev struct {
+	Name string
+
+	// ONLINE, DEGRADED, FAULTED, OFFLINE, REMOVED or UNAVAIL
+	State string
+
+	// Error counters
+	Read     int64
+	Write    int64
+	Checksum int64
+
+	// Children of mirrors, raidz and root vdevs
+	Vdevs []Vdev
+}
+
+type PoolStatus struct {
+	Name  string
+	State string
+
+	// Root vdev named as pool, log, cache and spare devices are not
+	// included
+	Root Vdev
+
+	// Number of data errors
+	ErrorCount int64
+}
+
+// See Zfs.GetPoolStatus
+func GetPoolStatus(pool string) (PoolStatus, error) {
+	return std().GetPoolStatus(pool)
+}
+
+// Returns state and device configuration of pool
+func (z Zfs) GetPoolStatus(pool string) (PoolStatus, error) {
+	json := z.useJSON()
+
+	format := "-p"
+	if json {
+		format = "-jp"
+	}
+
+	c := z.Command("zpool", "status", format, pool)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return PoolStatus{}, parseError(err, stderr)
+	}
+
+	var statuses []PoolStatus
+	if json {
+		statuses, err = parseJSONPoolStatus(stdout)
+	} else {
+		statuses, err = parsePoolStatus(string(stdout))
+	}
+	if err != nil {
+		return PoolStatus{}, err
+	}
+
+	if len(statuses) != 1 || statuses[0].Name != pool {
+		return PoolStatus{}, errors.New("unexpected zpool status output for " + pool)
+	}
+
+	return statuses[0], nil
+}
+
+var poolDataErrors = regexp.MustCompile(`^(\d+) data errors`)
+
+func parsePoolStatus(output string) ([]PoolStatus, error) {
+	statuses := []PoolStatus{}
+	config := false
+
+	// Vdevs being parsed with their indentation, root first
+	parents := []*Vdev{}
+	indents := []int{}
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "pool: ") {
+			statuses = append(statuses, PoolStatus{
+				Name: strings.TrimPrefix(trimmed, "pool: "),
+			})
+			config = false
+			continue
+		}
+		if len(statuses) == 0 {
+			continue
+		}
+		status := &statuses[len(statuses)-1]
+
+		switch {
+		case strings.HasPrefix(trimmed, "state: "):
+			status.State = strings.TrimPrefix(trimmed, "state: ")
+			continue
+		case strings.HasPrefix(trimmed, "errors: "):
+			errs := strings.TrimPrefix(trimmed, "errors: ")
+			if match := poolDataErrors.FindStringSubmatch(errs); match != nil {
+				status.ErrorCount, _ = strconv.ParseInt(match[1], 10, 64)
+			}
+			config = false
+			continue
+		case strings.HasPrefix(trimmed, "NAME "):
+			config = true
+			parents, indents = []*Vdev{}, []int{}
+			continue
+		}
+
+		if !config || trimmed == "" {
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		indent := len(strings.TrimLeft(line, "\t")) -
+			len(strings.TrimLeft(strings.TrimLeft(line, "\t"), " "))
+
+		// Sections like logs, cache or spares follow main vdevs
+		if len(fields) < 5 {
+			if indent == 0 {
+				config = false
+			}
+			continue
+		}
+
+		vdev, err := parseVdev(fields)
+		if err != nil {
+			return statuses, errors.New("unexpected zpool status output: " + line)
+		}
+
+		if len(parents) == 0 {
+			status.Root = vdev
+			parents, indents = []*Vdev{&status.Root}, []int{indent}
+			continue
+		}
+
+		for len(parents) > 1 && indents[len(indents)-1] >= indent {
+			parents, indents = parents[:len(parents)-1], indents[:len(indents)-1]
+		}
+		if indent <= indents[0] {
+			// Another top level line, like special or dedup section
+			config = false
+			continue
+		}
+
+		parent := parents[len(parents)-1]
+		parent.Vdevs = append(parent.Vdevs, vdev)
+		parents = append(parents, &parent.Vdevs[len(parent.Vdevs)-1])
+		indents = append(indents, indent)
+	}
+
+	return statuses, nil
+}
+
+func parseVdev(fields []string) (Vdev, error) {
+	vdev := Vdev{Name: fields[0], State: fields[1]}
+
+	counters := []*int64{&vdev.Read, &vdev.Write, &vdev.Checksum}
+	for i, counter := range counters {
+		value, err := strconv.ParseInt(fields[i+2], 10, 64)
+		if err != nil {
+			return vdev, err
+		}
+		*counter = value
+	}
+
+	return vdev, nil
+}
+
+func parseJSONPoolStatus(output []byte) ([]PoolStatus, error) {
+	statuses := []PoolStatus{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+
+	err := decodeJSONMembers(decoder, func(key string) error {
+		if key != "pools" {
+			var skip json.RawMessage
+			return decoder.Decode(&skip)
+		}
+
+		return decodeJSONMembers(decoder, func(string) error {
+			status, err := decodeJSONPoolStatus(decoder)
+			statuses = append(statuses, status)
+			return err
+		})
+	})
+	if err != nil {
+		return statuses, errors.New("error parsing zpool json output: " + err.Error())
+	}
+
+	return statuses, nil
+}
+
+func decodeJSONPoolStatus(decoder *json.Decoder) (PoolStatus, error) {
+	status := PoolStatus{}
+
+	err := decodeJSONMembers(decoder, func(key string) error {
+		switch key {
+		case "name":
+			return decoder.Decode(&status.Name)
+		case "state":
+			return decoder.Decode(&status.State)
+		case "error_count":
+			return decodeJSONInt(decoder, &status.ErrorCount)
+		case "vdevs":
+			return decodeJSONMembers(decoder, func(string) error {
+				var err error
+				status.Root, err = decodeJSONVdev(decoder)
+				return err
+			})
+		default:
+			var skip json.RawMessage
+			return decoder.Decode(&skip)
+		}
+	})
+
+	return status, err
+}
+
+// Children are decoded in order printed by zpool, which is lost with maps
+func decodeJSONVdev(decoder *json.Decoder) (Vdev, error) {
+	vdev := Vdev{}
+
+	err := decodeJSONMembers(decoder, func(key string) error {
+		switch key {
+		case "name":
+			return decoder.Decode(&vdev.Name)
+		case "state":
+			return decoder.Decode(&vdev.State)
+		case "read_errors":
+			return decodeJSONInt(decoder, &vdev.Read)
+		case "write_errors":
+			return decodeJSONInt(decoder, &vdev.Write)
+		case "checksum_errors":
+			return decodeJSONInt(decoder, &vdev.Checksum)
+		case "vdevs":
+			return decodeJSONMembers(decoder, func(string) error {
+				child, err := decodeJSONVdev(decoder)
+				vdev.Vdevs = append(vdev.Vdevs, child)
+				return err
+			})
+		default:
+			var skip json.RawMessage
+			return decoder.Decode(&skip)
+		}
+	})
+
+	return vdev, err
+}
+
+func decodeJSONInt(decoder *json.Decoder, value *int64) error {
+	var raw jsonValue
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	parsed, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*value = parsed
+	return nil
+}
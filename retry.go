@@ -0,0 +1,221 @@
+package zfs
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/theairkit/runcmd"
+)
+
+// Controls retries of idempotent operations failed with transient errors
+type RetryPolicy struct {
+	// Total number of attempts, one means no retries
+	MaxAttempts int
+
+	// Delay before second attempt, multiplied by Multiplier for every
+	// next attempt, but not more than MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+
+	// Fraction of delay randomly subtracted from it, from 0 to 1
+	Jitter float64
+
+	// Errors matching any of regexps are retried
+	Retryable []*regexp.Regexp
+
+	// Waits between attempts, time.Sleep if nil
+	Sleep func(time.Duration)
+}
+
+// Three attempts starting with half second delay, retries busy datasets
+// and broken pipes
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+		Retryable:    []*regexp.Regexp{DatasetBusy, BrokenPipe},
+	}
+}
+
+// Retries idempotent commands (get, list, set, inherit, destroy and others
+// which may be safely repeated) and sends to ZfsEntry. Destroy repeated
+// after failed attempt succeeds if dataset does not exist anymore, since
+// failed attempt may have destroyed it.
+func WithRetry(policy RetryPolicy) Option {
+	return func(z *ZfsRunner) error {
+		if policy.MaxAttempts < 1 {
+			return errors.New("retry policy should allow at least one attempt")
+		}
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return errors.New("retry jitter should be from 0 to 1")
+		}
+
+		z.retry = policy
+		return nil
+	}
+}
+
+// Optional Observer extension, called before every repeated attempt
+type RetryObserver interface {
+	RetryCommand(RetryEvent)
+}
+
+type RetryEvent struct {
+	// Redacted command of failed attempt
+	Args []string
+
+	// Number of next attempt, starting from 2
+	Attempt int
+
+	// Delay before next attempt
+	Delay time.Duration
+
+	// Error of failed attempt
+	Err error
+}
+
+// Commands which can be repeated without changing result
+var idempotentCommands = map[string]bool{
+	"list": true, "get": true, "set": true, "inherit": true,
+	"destroy": true, "version": true, "userspace": true,
+	"groupspace": true, "projectspace": true, "holds": true,
+	"status": true,
+}
+
+// Message is checked line by line and by errors joined with "; ", since
+// error regexps match end of line
+func (p RetryPolicy) retryable(message string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		for _, part := range strings.Split(line, "; ") {
+			part = strings.TrimSpace(part)
+			for _, retryable := range p.Retryable {
+				if retryable.MatchString(part) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	delay -= delay * p.Jitter * rand.Float64()
+
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) sleep(delay time.Duration) {
+	if p.Sleep != nil {
+		p.Sleep(delay)
+		return
+	}
+
+	time.Sleep(delay)
+}
+
+// Destroy may fail after removing dataset, e.g. on busy unmount of
+// recursively destroyed children
+func destroyed(stderr []byte) bool {
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if NotExist.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (z ZfsRunner) retries(name string, args []string) bool {
+	if z.retry.MaxAttempts < 2 || (name != "zfs" && name != "zpool") {
+		return false
+	}
+
+	return len(args) > 0 && idempotentCommands[args[0]]
+}
+
+// Calls run until it succeeds, returns error which is not retryable or
+// attempts are exhausted. Run returns error with message used to classify
+// it, like command stderr.
+func (z *ZfsRunner) withRetry(
+	argv []string, run func(attempt int) (string, error),
+) error {
+	runner := z.current()
+	return runner.retryLoop(argv, run)
+}
+
+func (z ZfsRunner) retryLoop(
+	argv []string, run func(attempt int) (string, error),
+) error {
+	for attempt := 1; ; attempt++ {
+		message, err := run(attempt)
+		if err == nil || attempt >= z.retry.MaxAttempts ||
+			!z.retry.retryable(message) {
+			return err
+		}
+
+		delay := z.retry.delay(attempt)
+		if observer, ok := z.observer.(RetryObserver); ok {
+			observer.RetryCommand(RetryEvent{
+				Args:    z.redact(argv),
+				Attempt: attempt + 1,
+				Delay:   delay,
+				Err:     err,
+			})
+		}
+
+		z.retry.sleep(delay)
+	}
+}
+
+// Runs command again with new worker if it fails with retryable error.
+// Only Output is retried, started commands are not.
+type retryWorker struct {
+	runcmd.CmdWorker
+	runner  ZfsRunner
+	argv    []string
+	destroy bool
+	create  func() runcmd.CmdWorker
+}
+
+func (w retryWorker) Output() ([]byte, []byte, error) {
+	var stdout, stderr []byte
+
+	err := w.runner.retryLoop(w.argv, func(attempt int) (string, error) {
+		worker := w.CmdWorker
+		if attempt > 1 {
+			worker = w.create()
+		}
+
+		var err error
+		stdout, stderr, err = worker.Output()
+		if err != nil && attempt > 1 && w.destroy && destroyed(stderr) {
+			stdout, stderr = nil, nil
+			return "", nil
+		}
+		if err != nil {
+			return string(stderr) + "\n" + err.Error(), err
+		}
+		return "", nil
+	})
+
+	return stdout, stderr, err
+}
@@ -0,0 +1,193 @@
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Flags passed to zfs send
+type SendOptions struct {
+	// Include dataset properties (-p)
+	Props bool
+
+	// Send encrypted data as is (-w)
+	Raw bool
+
+	// Send compressed blocks without decompression (-c)
+	Compressed bool
+
+	// Allow blocks larger than 128K (-L)
+	LargeBlock bool
+
+	// Send embedded blocks as is (-e)
+	Embedded bool
+
+	// Send dataset with all descendents and snapshots (-R)
+	Replicate bool
+
+	// Send all intermediary snapshots between base and snapshot (-I
+	// instead of -i), used only for incremental sends
+	Intermediary bool
+
+	// Check that target has enough space for stream before sending, used
+	// only by SendWithOptions
+	CheckSpace bool
+}
+
+func (o SendOptions) args(s Snapshot, base *Snapshot) []string {
+	args := []string{"send"}
+
+	if o.Props {
+		args = append(args, "-p")
+	}
+	if o.Raw {
+		args = append(args, "-w")
+	}
+	if o.Compressed {
+		args = append(args, "-c")
+	}
+	if o.LargeBlock {
+		args = append(args, "-L")
+	}
+	if o.Embedded {
+		args = append(args, "-e")
+	}
+	if o.Replicate {
+		args = append(args, "-R")
+	}
+
+	if base != nil {
+		if o.Intermediary {
+			args = append(args, "-I", base.Path)
+		} else {
+			args = append(args, "-i", base.Path)
+		}
+	}
+
+	return append(args, s.Path)
+}
+
+// Sends snapshot to given entry. If base is not nil incremental stream
+// from base is sent.
+func (s Snapshot) SendWithOptions(
+	base *Snapshot, to ZfsEntry, opts SendOptions,
+) error {
+	if err := opts.check(s); err != nil {
+		return err
+	}
+
+	if opts.CheckSpace {
+		if _, err := s.CheckSendSpace(base, to, opts); err != nil {
+			return err
+		}
+	}
+
+	// zfs receive expects plain stream
+	s.transfer.Wrappers = nil
+
+	// receive without -s leaves nothing on failure and every attempt waits
+	// for its receive to exit, so send is restarted from scratch on broken
+	// pipe
+	argv := append([]string{"zfs"}, opts.args(s, base)...)
+	return s.runner.withRetry(argv, func(int) (string, error) {
+		return s.send(base, to, opts)
+	})
+}
+
+// Returns error with message of send part used to decide if send may be
+// retried
+func (s Snapshot) send(
+	base *Snapshot, to ZfsEntry, opts SendOptions,
+) (string, error) {
+	rc, stdinPipe, err := to.Receive()
+	if err != nil {
+		return err.Error(), err
+	}
+
+	sendErr := s.SendStreamWithOptions(base, stdinPipe, opts)
+
+	err = finishReceive(rc, stdinPipe, sendErr)
+	switch {
+	case sendErr != nil:
+		return sendErr.Error(), err
+	case err != nil:
+		return err.Error(), err
+	default:
+		return "", nil
+	}
+}
+
+// Writes snapshot stream to dest. If base is not nil incremental stream
+// from base is written.
+func (s Snapshot) SendStreamWithOptions(
+	base *Snapshot, dest io.Writer, opts SendOptions,
+) error {
+	if err := opts.check(s); err != nil {
+		return err
+	}
+
+	if ok, _ := s.Exists(); !ok {
+		return notExits(s)
+	}
+	if base != nil {
+		if ok, _ := base.Exists(); !ok {
+			return notExits(*base)
+		}
+	}
+
+	return s.sendStream(dest, opts.args(s, base)...)
+}
+
+// Returns size of stream which will be sent with given options, as
+// reported by 'zfs send -nvP'
+func (s Snapshot) EstimateSendSize(
+	base *Snapshot, opts SendOptions,
+) (int64, error) {
+	if err := opts.check(s); err != nil {
+		return 0, err
+	}
+
+	return s.sendSize(opts.args(s, base))
+}
+
+// Estimates stream size and compares it with space available on target.
+// Target may not exist yet, then nearest existing parent is checked.
+// Returns estimated size.
+func (s Snapshot) CheckSendSpace(
+	base *Snapshot, to ZfsEntry, opts SendOptions,
+) (int64, error) {
+	size, err := s.EstimateSendSize(base, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	target := to.getPath()
+	for {
+		fs := to.getRunner().NewFs(target)
+		ok, err := fs.Exists()
+		if err != nil {
+			return size, err
+		}
+
+		if ok {
+			available, err := fs.GetPropertyInt("available")
+			if err != nil {
+				return size, err
+			}
+			if available < size {
+				return size, errors.New(fmt.Sprintf(
+					"not enough space on '%s': need %d bytes, available %d",
+					target, size, available,
+				))
+			}
+			return size, nil
+		}
+
+		parent, ok := ParentPath(target)
+		if !ok {
+			return size, notExits(fs)
+		}
+		target = parent
+	}
+}
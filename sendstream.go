@@ -0,0 +1,378 @@
+package zfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Parser for zfs send stream format, works like 'zstream dump' without
+// need of zfs tools or pool
+
+type StreamRecordType uint32
+
+const (
+	DRR_BEGIN StreamRecordType = iota
+	DRR_OBJECT
+	DRR_FREEOBJECTS
+	DRR_WRITE
+	DRR_FREE
+	DRR_END
+	DRR_WRITE_BYREF
+	DRR_SPILL
+	DRR_WRITE_EMBEDDED
+	DRR_OBJECT_RANGE
+	DRR_REDACT
+)
+
+var streamRecordNames = []string{
+	"BEGIN", "OBJECT", "FREEOBJECTS", "WRITE", "FREE", "END",
+	"WRITE_BYREF", "SPILL", "WRITE_EMBEDDED", "OBJECT_RANGE", "REDACT",
+}
+
+func (t StreamRecordType) String() string {
+	if int(t) < len(streamRecordNames) {
+		return streamRecordNames[t]
+	}
+
+	return fmt.Sprintf("UNKNOWN(%d)", uint32(t))
+}
+
+// Send stream feature flags, stored in DRR_BEGIN record
+type StreamFeature uint64
+
+const (
+	StreamFeatureDedup         StreamFeature = 1 << 0
+	StreamFeatureDedupProps    StreamFeature = 1 << 1
+	StreamFeatureSASpill       StreamFeature = 1 << 2
+	StreamFeatureEmbedData     StreamFeature = 1 << 16
+	StreamFeatureLZ4           StreamFeature = 1 << 17
+	StreamFeatureLargeBlocks   StreamFeature = 1 << 19
+	StreamFeatureResuming      StreamFeature = 1 << 20
+	StreamFeatureRedacted      StreamFeature = 1 << 21
+	StreamFeatureCompressed    StreamFeature = 1 << 22
+	StreamFeatureLargeDnode    StreamFeature = 1 << 23
+	StreamFeatureRaw           StreamFeature = 1 << 24
+	StreamFeatureZstd          StreamFeature = 1 << 25
+	StreamFeatureHolds         StreamFeature = 1 << 26
+	StreamFeatureLargeMicrozap StreamFeature = 1 << 27
+)
+
+const (
+	streamMagic      = 0x2F5bacbac
+	streamRecordSize = 312
+
+	// offset of trailing checksum in every record
+	streamChecksumOffset = streamRecordSize - 32
+
+	// header types stored in lower bits of drr_versioninfo
+	streamSubstream = 1
+	streamCompound  = 2
+
+	// drr_toname size
+	streamNameLen = 256
+)
+
+var BadStreamMagic = errors.New("not a zfs send stream: bad magic")
+
+// Contents of DRR_BEGIN record
+type StreamHeader struct {
+	ToGUID       uint64
+	FromGUID     uint64
+	Name         string
+	CreationTime time.Time
+	Features     StreamFeature
+	Flags        uint32
+
+	// Stream contains several substreams, sent with 'zfs send -R'
+	Compound bool
+}
+
+func (h StreamHeader) Incremental() bool {
+	return h.FromGUID != 0
+}
+
+func (h StreamHeader) Raw() bool {
+	return h.Features&StreamFeatureRaw != 0
+}
+
+func (h StreamHeader) Compressed() bool {
+	return h.Features&StreamFeatureCompressed != 0
+}
+
+// Summary of whole stream returned by InspectStream
+type StreamInfo struct {
+	StreamHeader
+
+	// Headers of substreams, if stream is compound
+	Substreams []StreamHeader
+
+	// Number of records by type
+	Records map[StreamRecordType]int64
+
+	// Total stream size in bytes
+	Size int64
+}
+
+// Reads only DRR_BEGIN record from stream
+func ReadStreamHeader(r io.Reader) (StreamHeader, error) {
+	reader := streamReader{src: r}
+	record, err := reader.next()
+	if err != nil {
+		return StreamHeader{}, err
+	}
+
+	return reader.header(record)
+}
+
+// Walks all stream records, verifying record checksums and checksums
+// stored in DRR_END records
+func InspectStream(r io.Reader) (StreamInfo, error) {
+	reader := streamReader{src: r}
+	info := StreamInfo{Records: map[StreamRecordType]int64{}}
+
+	first := true
+	for {
+		record, err := reader.next()
+		if err == io.EOF && !first && reader.ended {
+			return info, nil
+		}
+		if err != nil {
+			return info, err
+		}
+
+		info.Records[record.kind]++
+		info.Size = reader.read
+
+		if record.kind != DRR_BEGIN {
+			continue
+		}
+
+		header, err := reader.header(record)
+		if err != nil {
+			return info, err
+		}
+
+		if first {
+			info.StreamHeader = header
+			first = false
+		} else {
+			info.Substreams = append(info.Substreams, header)
+		}
+	}
+}
+
+type streamRecord struct {
+	kind    StreamRecordType
+	data    []byte
+	payload []byte
+}
+
+type streamReader struct {
+	src   io.Reader
+	order binary.ByteOrder
+	sum   fletcher4
+	read  int64
+
+	// last record was DRR_END
+	ended bool
+}
+
+func (r *streamReader) next() (streamRecord, error) {
+	data := make([]byte, streamRecordSize)
+	n, err := io.ReadFull(r.src, data)
+	r.read += int64(n)
+	if err == io.EOF {
+		return streamRecord{}, io.EOF
+	}
+	if err != nil {
+		return streamRecord{}, errors.New(
+			"error reading stream record: " + err.Error(),
+		)
+	}
+
+	if r.order == nil {
+		if err := r.detectOrder(data); err != nil {
+			return streamRecord{}, err
+		}
+	}
+
+	record := streamRecord{
+		kind: StreamRecordType(r.order.Uint32(data[0:4])),
+		data: data,
+	}
+
+	if err := r.checkRecord(record); err != nil {
+		return record, err
+	}
+
+	size, err := r.payloadSize(record)
+	if err != nil {
+		return record, err
+	}
+
+	if size > 0 {
+		record.payload = make([]byte, size)
+		n, err := io.ReadFull(r.src, record.payload)
+		r.read += int64(n)
+		if err != nil {
+			return record, errors.New(
+				"error reading " + record.kind.String() + " payload: " +
+					err.Error(),
+			)
+		}
+
+		r.sum.update(r.order, record.payload)
+	}
+
+	r.ended = record.kind == DRR_END
+	if r.ended {
+		// every substream has its own checksum
+		r.sum = fletcher4{}
+	}
+
+	return record, nil
+}
+
+func (r *streamReader) detectOrder(data []byte) error {
+	switch {
+	case binary.LittleEndian.Uint64(data[8:16]) == streamMagic:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint64(data[8:16]) == streamMagic:
+		r.order = binary.BigEndian
+	default:
+		return BadStreamMagic
+	}
+
+	return nil
+}
+
+func (r *streamReader) checkRecord(record streamRecord) error {
+	previous := r.sum
+
+	r.sum.update(r.order, record.data[:streamChecksumOffset])
+	stored := readChecksum(r.order, record.data[streamChecksumOffset:])
+	if record.kind != DRR_BEGIN && !stored.zero() && stored != r.sum {
+		return errors.New(fmt.Sprintf(
+			"invalid checksum of %s record at offset %d",
+			record.kind, r.read-streamRecordSize,
+		))
+	}
+	r.sum.update(r.order, record.data[streamChecksumOffset:])
+
+	if record.kind == DRR_END {
+		// DRR_END keeps checksum of everything before it
+		end := readChecksum(r.order, record.data[8:40])
+		if end != previous {
+			return errors.New(
+				"stream checksum differs from checksum in DRR_END record",
+			)
+		}
+	}
+
+	return nil
+}
+
+// Payload sizes are calculated from record fields like zstream does, since
+// drr_payloadlen is set only for DRR_BEGIN by older zfs versions
+func (r *streamReader) payloadSize(record streamRecord) (int64, error) {
+	union := record.data[8:]
+
+	switch record.kind {
+	case DRR_BEGIN:
+		return int64(r.order.Uint32(record.data[4:8])), nil
+
+	case DRR_OBJECT:
+		bonusLen := r.order.Uint32(union[20:24])
+		rawBonusLen := r.order.Uint32(union[28:32])
+		if rawBonusLen != 0 {
+			return int64(rawBonusLen), nil
+		}
+		return roundUp8(int64(bonusLen)), nil
+
+	case DRR_WRITE:
+		compression := union[42]
+		if compression != 0 {
+			return int64(r.order.Uint64(union[88:96])), nil
+		}
+		return int64(r.order.Uint64(union[24:32])), nil
+
+	case DRR_SPILL:
+		if compressed := r.order.Uint64(union[32:40]); compressed != 0 {
+			return int64(compressed), nil
+		}
+		return int64(r.order.Uint64(union[8:16])), nil
+
+	case DRR_WRITE_EMBEDDED:
+		return roundUp8(int64(r.order.Uint32(union[44:48]))), nil
+
+	case DRR_FREEOBJECTS, DRR_FREE, DRR_END, DRR_WRITE_BYREF,
+		DRR_OBJECT_RANGE, DRR_REDACT:
+		return 0, nil
+
+	default:
+		return 0, errors.New(fmt.Sprintf(
+			"unknown stream record type %d", uint32(record.kind),
+		))
+	}
+}
+
+func (r *streamReader) header(record streamRecord) (StreamHeader, error) {
+	if record.kind != DRR_BEGIN {
+		return StreamHeader{}, errors.New(
+			"stream starts with " + record.kind.String() + " record",
+		)
+	}
+
+	union := record.data[8:]
+	versionInfo := r.order.Uint64(union[8:16])
+
+	name := union[48 : 48+streamNameLen]
+	if end := bytes.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+
+	return StreamHeader{
+		Features:     StreamFeature(versionInfo >> 2 & (1<<30 - 1)),
+		Compound:     versionInfo&3 == streamCompound,
+		CreationTime: time.Unix(int64(r.order.Uint64(union[16:24])), 0),
+		Flags:        r.order.Uint32(union[28:32]),
+		ToGUID:       r.order.Uint64(union[32:40]),
+		FromGUID:     r.order.Uint64(union[40:48]),
+		Name:         string(name),
+	}, nil
+}
+
+func roundUp8(n int64) int64 {
+	return (n + 7) &^ 7
+}
+
+// Fletcher-4 checksum used by zfs send streams
+type fletcher4 [4]uint64
+
+func (f *fletcher4) update(order binary.ByteOrder, data []byte) {
+	a, b, c, d := f[0], f[1], f[2], f[3]
+	for i := 0; i+4 <= len(data); i += 4 {
+		a += uint64(order.Uint32(data[i : i+4]))
+		b += a
+		c += b
+		d += c
+	}
+	f[0], f[1], f[2], f[3] = a, b, c, d
+}
+
+func (f fletcher4) zero() bool {
+	return f == fletcher4{}
+}
+
+func readChecksum(order binary.ByteOrder, data []byte) fletcher4 {
+	return fletcher4{
+		order.Uint64(data[0:8]),
+		order.Uint64(data[8:16]),
+		order.Uint64(data[16:24]),
+		order.Uint64(data[24:32]),
+	}
+}
@@ -0,0 +1,182 @@
+package zfs
+
+import "strings"
+
+// Builder for sharenfs property value
+type NFSShare struct {
+	// Export read only to everyone
+	ReadOnly bool
+
+	// Hosts or networks (@10.0.0.0/24) with read-write access
+	ReadWrite []string
+
+	// Hosts or networks with read only access
+	ReadOnlyHosts []string
+
+	// Do not map requests from root to anonymous user
+	NoRootSquash bool
+
+	// Map requests from all users to anonymous user
+	AllSquash bool
+
+	// Any other options passed as is
+	Options []string
+}
+
+func (n NFSShare) String() string {
+	options := []string{}
+
+	if n.ReadOnly {
+		options = append(options, "ro")
+	}
+	if len(n.ReadWrite) > 0 {
+		options = append(options, "rw="+strings.Join(n.ReadWrite, ":"))
+	}
+	if len(n.ReadOnlyHosts) > 0 {
+		options = append(options, "ro="+strings.Join(n.ReadOnlyHosts, ":"))
+	}
+	if n.NoRootSquash {
+		options = append(options, "no_root_squash")
+	}
+	if n.AllSquash {
+		options = append(options, "all_squash")
+	}
+	options = append(options, n.Options...)
+
+	if len(options) == 0 {
+		return "on"
+	}
+
+	return strings.Join(options, ",")
+}
+
+// Builder for sharesmb property value. Linux supports only plain "on", name
+// and guest access are used on illumos.
+type SMBShare struct {
+	Name    string
+	GuestOK bool
+
+	// Any other options passed as is
+	Options []string
+}
+
+func (s SMBShare) String() string {
+	options := []string{}
+
+	if s.Name != "" {
+		options = append(options, "name="+s.Name)
+	}
+	if s.GuestOK {
+		options = append(options, "guestok=true")
+	}
+	options = append(options, s.Options...)
+
+	if len(options) == 0 {
+		return "on"
+	}
+
+	return strings.Join(options, ",")
+}
+
+func (f Fs) SetNFSShare(share NFSShare) error {
+	return f.SetProperty("sharenfs", share.String())
+}
+
+func (f Fs) SetSMBShare(share SMBShare) error {
+	return f.SetProperty("sharesmb", share.String())
+}
+
+// Turns off both NFS and SMB sharing
+func (f Fs) DisableShares() error {
+	if err := f.SetProperty("sharenfs", "off"); err != nil {
+		return err
+	}
+
+	return f.SetProperty("sharesmb", "off")
+}
+
+// Shares filesystem according to its sharenfs and sharesmb properties
+func (f Fs) Share() error {
+	c := f.runner.Command("zfs", "share", f.Path)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+func (f Fs) Unshare() error {
+	c := f.runner.Command("zfs", "unshare", f.Path)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// See Zfs.ShareAll
+func ShareAll() error {
+	return std().ShareAll()
+}
+
+// Shares all filesystems with sharenfs or sharesmb set
+func (z Zfs) ShareAll() error {
+	c := z.Command("zfs", "share", "-a")
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// See Zfs.UnshareAll
+func UnshareAll() error {
+	return std().UnshareAll()
+}
+
+func (z Zfs) UnshareAll() error {
+	c := z.Command("zfs", "unshare", "-a")
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
+// Filesystem shared over NFS or SMB
+type SharedFs struct {
+	Fs Fs
+
+	// sharenfs and sharesmb values, "off" if not shared
+	NFS string
+	SMB string
+}
+
+// See Zfs.ListShared
+func ListShared(path string) ([]SharedFs, error) {
+	return std().ListShared(path)
+}
+
+// Returns mounted filesystems with sharing enabled under given path
+func (z Zfs) ListShared(path string) ([]SharedFs, error) {
+	datasets, err := z.List(ListOptions{
+		Paths:      []string{path},
+		Types:      []DatasetType{TypeFilesystem},
+		Recursive:  true,
+		Properties: []string{"sharenfs", "sharesmb", "mounted"},
+	})
+	if err != nil {
+		return []SharedFs{}, err
+	}
+
+	shared := []SharedFs{}
+	for _, dataset := range datasets {
+		nfs, _ := dataset.Property("sharenfs")
+		smb, _ := dataset.Property("sharesmb")
+		mounted, _ := dataset.Property("mounted")
+
+		if mounted != "yes" || (nfs == "off" && smb == "off") {
+			continue
+		}
+
+		shared = append(shared, SharedFs{
+			Fs:  z.NewFs(dataset.getPath()),
+			NFS: nfs,
+			SMB: smb,
+		})
+	}
+
+	return shared, nil
+}
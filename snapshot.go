@@ -3,30 +3,57 @@ package zfs
 import (
 	"errors"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // See Zfs.NewSnapshot
-func NewSnapshot(snapshotPath string) Snapshot {
-	return std.NewSnapshot(snapshotPath)
+func NewSnapshot(snapshotPath string) (Snapshot, error) {
+	return std().NewSnapshot(snapshotPath)
 }
 
-// Return Snapshot wrapper without any checks and actualy creation
-func (z Zfs) NewSnapshot(snap string) Snapshot {
-	buf := strings.Split(snap, "@")
-	path := buf[0]
-	name := buf[1]
-	return Snapshot{zfsEntryBase{z, snap}, NewFs(path), name}
+// Return Snapshot wrapper without actualy creation, snapshot path is
+// checked with ParseName
+func (z Zfs) NewSnapshot(snap string) (Snapshot, error) {
+	name, err := ParseName(snap)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !name.IsSnapshot() {
+		return Snapshot{}, NameError{snap, "missing '@' delimiter"}
+	}
+
+	return z.newSnapshot(snap), nil
+}
+
+// Builds Snapshot from path returned by zfs
+func (z Zfs) newSnapshot(snap string) Snapshot {
+	path, name, _ := splitSnapshotPath(snap)
+	return Snapshot{zfsEntryBase: zfsEntryBase{z, snap}, Fs: z.NewFs(path), Name: name}
 }
 
 type Snapshot struct {
 	zfsEntryBase
 	Fs   Fs
 	Name string
+
+	transfer TransferOptions
+}
+
+// Returns copy of snapshot, which will use given options in all
+// Send* and SendStream* calls
+func (s Snapshot) WithTransfer(opts TransferOptions) Snapshot {
+	s.transfer = opts
+	return s
 }
 
 func (s Snapshot) Clone(targetPath string) (Fs, error) {
-	if s.GetPool() != NewFs(targetPath).GetPool() {
+	if err := checkDatasetName(targetPath); err != nil {
+		return Fs{}, err
+	}
+
+	if s.GetPool() != PoolName(targetPath) {
 		return Fs{}, PoolError
 	}
 
@@ -40,8 +67,30 @@ func (s Snapshot) Clone(targetPath string) (Fs, error) {
 	return Fs{zfsEntryBase{s.runner, targetPath}}, nil
 }
 
+// Rolls filesystem back to snapshot. RF_Soft destroys later snapshots and
+// bookmarks, RF_Hard also destroys their clones.
+func (s Snapshot) Rollback(recursive RecursiveFlag) error {
+	args := []string{"rollback"}
+
+	switch recursive {
+	case RF_Soft:
+		args = append(args, "-r")
+	case RF_Hard:
+		args = append(args, "-R")
+	}
+
+	c := s.runner.Command("zfs", append(args, s.Path)...)
+
+	_, stderr, err := c.Output()
+	return parseError(err, stderr)
+}
+
 func (f Fs) Snapshot(name string) (Snapshot, error) {
 	snapshotPath := f.Path + "@" + name
+	if _, err := ParseName(snapshotPath); err != nil {
+		return Snapshot{}, err
+	}
+
 	c := f.runner.Command("zfs", "snapshot", snapshotPath)
 
 	_, stderr, err := c.Output()
@@ -49,11 +98,37 @@ func (f Fs) Snapshot(name string) (Snapshot, error) {
 		return Snapshot{}, parseError(err, stderr)
 	}
 
-	snap := Snapshot{zfsEntryBase{f.runner, snapshotPath}, f, name}
+	snap := Snapshot{
+		zfsEntryBase: zfsEntryBase{f.runner, snapshotPath},
+		Fs:           f,
+		Name:         name,
+	}
 	return snap, nil
 }
 
 func (f Fs) ListSnapshots() ([]Snapshot, error) {
+	if f.runner.useJSON() {
+		datasets, err := f.runner.list(ListOptions{
+			Paths:     []string{f.Path},
+			Types:     []DatasetType{TypeSnapshot},
+			Recursive: true,
+		})
+		if err != nil {
+			return []Snapshot{}, err
+		}
+
+		snapshots := []Snapshot{}
+		for _, dataset := range datasets {
+			snapshot, ok := dataset.ZfsEntry.(Snapshot)
+			if !ok {
+				continue
+			}
+			snapshot.Fs = f
+			snapshots = append(snapshots, snapshot)
+		}
+		return snapshots, nil
+	}
+
 	c := f.runner.Command(
 		"zfs", "list", "-Hr", "-o", "name", "-t", "snapshot", f.Path,
 	)
@@ -65,12 +140,11 @@ func (f Fs) ListSnapshots() ([]Snapshot, error) {
 
 	snapshots := []Snapshot{}
 	for _, snap := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
-		if strings.Contains(snap, "@") {
-			snapName := strings.Split(snap, "@")[1]
+		if _, snapName, ok := splitSnapshotPath(snap); ok {
 			snapshots = append(snapshots, Snapshot{
-				zfsEntryBase{f.runner, snap},
-				f,
-				snapName,
+				zfsEntryBase: zfsEntryBase{f.runner, snap},
+				Fs:           f,
+				Name:         snapName,
 			})
 		} else {
 			continue
@@ -87,118 +161,63 @@ func notExits(e ZfsEntry) error {
 }
 
 func (s Snapshot) Send(to ZfsEntry) error {
-	rc, stdinPipe, err := to.Receive()
-	if err != nil {
-		return err
-	}
-
-	err = s.SendStream(stdinPipe)
-	if err != nil {
-		return err
-	}
-
-	return parseError(rc.Wait(), nil)
+	return s.SendWithOptions(nil, to, SendOptions{})
 }
 
 func (s Snapshot) SendWithParams(to ZfsEntry) error {
-	rc, stdinPipe, err := to.Receive()
-	if err != nil {
-		return err
-	}
-
-	err = s.SendStreamWithParams(stdinPipe)
-	if err != nil {
-		return err
-	}
-
-	return parseError(rc.Wait(), nil)
+	return s.SendWithOptions(nil, to, SendOptions{Props: true})
 }
 
 func (s Snapshot) SendIncrementalWithParams(base Snapshot, to ZfsEntry) error {
-	rc, stdinPipe, err := to.Receive()
-	if err != nil {
-		return err
-	}
-
-	err = s.SendIncrementalStreamWithParams(base, stdinPipe)
-	if err != nil {
-		return err
-	}
-
-	return parseError(rc.Wait(), nil)
+	return s.SendWithOptions(&base, to, SendOptions{Props: true})
 }
 
 func (s Snapshot) SendIncremental(base Snapshot, to ZfsEntry) error {
-	rc, stdinPipe, err := to.Receive()
-	if err != nil {
-		return err
-	}
-
-	err = s.SendIncrementalStream(base, stdinPipe)
-	if err != nil {
-		return err
-	}
-
-	return parseError(rc.Wait(), nil)
+	return s.SendWithOptions(&base, to, SendOptions{})
 }
 
 func (s Snapshot) SendStream(dest io.Writer) error {
-	if ok, _ := s.Exists(); !ok {
-		return notExits(s)
-	}
-
-	c := s.runner.Command("zfs", "send", s.Path)
-
-	stdoutPipe, err := c.StdoutPipe()
-	if err != nil {
-		return err
-	}
-
-	if err := c.Start(); err != nil {
-		return err
-	}
-
-	_, err = io.Copy(dest, stdoutPipe)
-	if err != nil {
-		return err
-	}
-
-	return parseError(c.Wait(), nil)
+	return s.SendStreamWithOptions(nil, dest, SendOptions{})
 }
 
 func (s Snapshot) SendStreamWithParams(dest io.Writer) error {
-	if ok, _ := s.Exists(); !ok {
-		return notExits(s)
-	}
+	return s.SendStreamWithOptions(nil, dest, SendOptions{Props: true})
+}
 
-	c := s.runner.Command("zfs", "send", "-p", s.Path)
+func (s Snapshot) SendIncrementalStream(base Snapshot, dest io.Writer) error {
+	return s.SendStreamWithOptions(&base, dest, SendOptions{})
+}
 
-	stdoutPipe, err := c.StdoutPipe()
-	if err != nil {
-		return err
-	}
+func (s Snapshot) SendIncrementalStreamWithParams(
+	base Snapshot, dest io.Writer,
+) error {
+	return s.SendStreamWithOptions(&base, dest, SendOptions{Props: true})
+}
 
-	if err := c.Start(); err != nil {
-		return err
-	}
+// Runs zfs with given send arguments and copies its output to dest
+// according to snapshot transfer options. In dry-run mode nothing is
+// written, send is only estimated.
+func (s Snapshot) sendStream(dest io.Writer, args ...string) error {
+	if s.runner.IsDryRun() {
+		size, err := s.sendSize(args)
+		if err != nil {
+			return err
+		}
 
-	_, err = io.Copy(dest, stdoutPipe)
-	if err != nil {
-		return err
+		s.runner.planSend(args, size)
+		return nil
 	}
 
-	return parseError(c.Wait(), nil)
-}
-
-func (s Snapshot) SendIncrementalStream(base Snapshot, dest io.Writer) error {
-	if ok, _ := s.Exists(); !ok {
-		return notExits(s)
-	}
-	if ok, _ := base.Exists(); !ok {
-		return notExits(base)
+	var total int64
+	if s.transfer.Progress != nil {
+		size, err := s.sendSize(args)
+		if err != nil {
+			return err
+		}
+		total = size
 	}
 
-	c := s.runner.Command("zfs", "send", "-i", base.Path, s.Path)
+	c := s.runner.Command("zfs", args...)
 
 	stdoutPipe, err := c.StdoutPipe()
 	if err != nil {
@@ -209,60 +228,73 @@ func (s Snapshot) SendIncrementalStream(base Snapshot, dest io.Writer) error {
 		return errors.New("error starting send: " + err.Error())
 	}
 
-	_, err = io.Copy(dest, stdoutPipe)
-	if err != nil {
-		return errors.New("error copying to dest: " + err.Error())
+	_, copyErr := s.transfer.copy(dest, stdoutPipe, total)
+	if copyErr != nil {
+		// zfs send blocks on full pipe until it is closed or read
+		if closer, ok := stdoutPipe.(io.Closer); ok {
+			closer.Close()
+		} else {
+			io.Copy(io.Discard, stdoutPipe)
+		}
 	}
 
-	return parseError(c.Wait(), nil)
-}
+	waitErr := parseError(c.Wait(), nil)
 
-func (s Snapshot) SendIncrementalStreamWithParams(
-	base Snapshot,
-	dest io.Writer,
-) error {
-	if ok, _ := s.Exists(); !ok {
-		return notExits(s)
-	}
-	if ok, _ := base.Exists(); !ok {
-		return notExits(base)
+	switch {
+	case copyErr != nil && waitErr != nil:
+		return errors.New("error copying to dest: " + copyErr.Error() +
+			"; send: " + waitErr.Error())
+	case copyErr != nil:
+		return errors.New("error copying to dest: " + copyErr.Error())
+	default:
+		return waitErr
 	}
+}
 
-	c := s.runner.Command("zfs", "send", "-p", "-i", base.Path, s.Path)
+// Returns stream size reported by 'zfs send -nvP' with given send arguments
+func (s Snapshot) sendSize(args []string) (int64, error) {
+	dryArgs := append([]string{args[0], "-nvP"}, args[1:]...)
+	c := s.runner.Command("zfs", dryArgs...)
 
-	stdoutPipe, err := c.StdoutPipe()
+	stdout, stderr, err := c.Output()
 	if err != nil {
-		return err
+		return 0, parseError(err, stderr)
 	}
 
-	if err := c.Start(); err != nil {
-		return errors.New("error starting send: " + err.Error())
-	}
+	// older zfs versions print dry run info to stderr
+	output := string(stdout) + "\n" + string(stderr)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "size" {
+			continue
+		}
 
-	_, err = io.Copy(dest, stdoutPipe)
-	if err != nil {
-		return errors.New("error copying to dest: " + err.Error())
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.New("error parsing send size: " + err.Error())
+		}
+		return size, nil
 	}
 
-	return parseError(c.Wait(), nil)
+	return 0, errors.New("send size not found in 'zfs send -nvP' output")
 }
 
+// Returns clones of snapshot sorted by path, using snapshot clones property
 func (s Snapshot) ListClones() ([]Fs, error) {
-	fss, err := ListFs(s.GetPool())
+	value, err := s.GetProperty("clones")
 	if err != nil {
 		return []Fs{}, err
 	}
 
 	clones := []Fs{}
-	for _, fs := range fss {
-		origin, err := fs.GetProperty("origin")
-		if err != nil {
-			return []Fs{}, err
-		}
+	if value == "" || value == "-" {
+		return clones, nil
+	}
 
-		if origin == s.Path {
-			clones = append(clones, fs)
-		}
+	paths := strings.Split(value, ",")
+	sort.Strings(paths)
+	for _, path := range paths {
+		clones = append(clones, s.runner.NewFs(path))
 	}
 
 	return clones, nil
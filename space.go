@@ -0,0 +1,171 @@
+package zfs
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = "KMGTPE"
+
+// Decimal number with optional single suffix, like 1.5T, 512KB or 2MiB
+var sizeNotation = regexp.MustCompile(`^(\d+(?:\.\d+)?)(?:([KMGTPE])(?:I?B)?|B)?$`)
+
+// Parses size in zfs notation, like 1024, 10G, 1.5T or 512KB, into bytes.
+// Suffixes are powers of 1024, "none" is parsed as zero. Fractional bytes
+// are truncated.
+func ParseSize(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	if value == "NONE" {
+		return 0, nil
+	}
+
+	match := sizeNotation.FindStringSubmatch(value)
+	if match == nil {
+		return 0, errors.New("invalid size '" + size + "'")
+	}
+
+	// exact decimal arithmetic, float loses precision of large sizes
+	bytes, ok := new(big.Rat).SetString(match[1])
+	if !ok {
+		return 0, errors.New("invalid size '" + size + "'")
+	}
+	if match[2] != "" {
+		power := uint(10 * (strings.Index(sizeSuffixes, match[2]) + 1))
+		bytes.Mul(bytes, new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), power)))
+	}
+
+	whole := new(big.Int).Quo(bytes.Num(), bytes.Denom())
+	if !whole.IsInt64() {
+		return 0, errors.New("size '" + size + "' is too large")
+	}
+
+	return whole.Int64(), nil
+}
+
+// Returns true if both values are sizes, equal in bytes
+func sameSize(a, b string) bool {
+	sizeA, err := ParseSize(a)
+	if err != nil {
+		return false
+	}
+
+	sizeB, err := ParseSize(b)
+	if err != nil {
+		return false
+	}
+
+	return sizeA == sizeB
+}
+
+// Sets size property, zero bytes means none
+func (f Fs) setSize(prop string, bytes int64) error {
+	value := "none"
+	if bytes > 0 {
+		value = strconv.FormatInt(bytes, 10)
+	}
+
+	return f.SetProperty(prop, value)
+}
+
+// Returns quota in bytes, zero if not set
+func (f Fs) Quota() (int64, error) {
+	return f.GetPropertyInt("quota")
+}
+
+func (f Fs) SetQuota(bytes int64) error {
+	return f.setSize("quota", bytes)
+}
+
+func (f Fs) RefQuota() (int64, error) {
+	return f.GetPropertyInt("refquota")
+}
+
+func (f Fs) SetRefQuota(bytes int64) error {
+	return f.setSize("refquota", bytes)
+}
+
+func (f Fs) Reservation() (int64, error) {
+	return f.GetPropertyInt("reservation")
+}
+
+func (f Fs) SetReservation(bytes int64) error {
+	return f.setSize("reservation", bytes)
+}
+
+func (f Fs) RefReservation() (int64, error) {
+	return f.GetPropertyInt("refreservation")
+}
+
+func (f Fs) SetRefReservation(bytes int64) error {
+	return f.setSize("refreservation", bytes)
+}
+
+// Returns quota of user given by name or id, zero if not set
+func (f Fs) UserQuota(user string) (int64, error) {
+	return f.GetPropertyInt("userquota@" + user)
+}
+
+func (f Fs) SetUserQuota(user string, bytes int64) error {
+	return f.setSize("userquota@"+user, bytes)
+}
+
+func (f Fs) GroupQuota(group string) (int64, error) {
+	return f.GetPropertyInt("groupquota@" + group)
+}
+
+func (f Fs) SetGroupQuota(group string, bytes int64) error {
+	return f.setSize("groupquota@"+group, bytes)
+}
+
+func (f Fs) ProjectQuota(project int) (int64, error) {
+	return f.GetPropertyInt("projectquota@" + strconv.Itoa(project))
+}
+
+func (f Fs) SetProjectQuota(project int, bytes int64) error {
+	return f.setSize("projectquota@"+strconv.Itoa(project), bytes)
+}
+
+// Space accounting of dataset in bytes
+type SpaceBreakdown struct {
+	Used                 int64
+	Available            int64
+	Referenced           int64
+	UsedBySnapshots      int64
+	UsedByDataset        int64
+	UsedByChildren       int64
+	UsedByRefReservation int64
+}
+
+func (f Fs) SpaceBreakdown() (SpaceBreakdown, error) {
+	props, err := f.GetProperties(
+		"used", "available", "referenced", "usedbysnapshots",
+		"usedbydataset", "usedbychildren", "usedbyrefreservation",
+	)
+	if err != nil {
+		return SpaceBreakdown{}, err
+	}
+
+	values := map[string]int64{}
+	for name, prop := range props {
+		value, err := ParseSize(prop.Value)
+		if err != nil {
+			return SpaceBreakdown{}, errors.New(
+				"error parsing " + name + ": " + err.Error(),
+			)
+		}
+		values[name] = value
+	}
+
+	return SpaceBreakdown{
+		Used:                 values["used"],
+		Available:            values["available"],
+		Referenced:           values["referenced"],
+		UsedBySnapshots:      values["usedbysnapshots"],
+		UsedByDataset:        values["usedbydataset"],
+		UsedByChildren:       values["usedbychildren"],
+		UsedByRefReservation: values["usedbyrefreservation"],
+	}, nil
+}
@@ -0,0 +1,320 @@
+package zfs
+
+import (
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// Transforms snapshot stream on the fly. Writer side is used by
+// SendStream*, reader side by ReceiveStream.
+type StreamWrapper interface {
+	WrapWriter(io.Writer) (io.WriteCloser, error)
+	WrapReader(io.Reader) (io.ReadCloser, error)
+}
+
+type CompressionAlgorithm string
+
+const (
+	CompressGzip CompressionAlgorithm = "gzip"
+	CompressZstd CompressionAlgorithm = "zstd"
+	CompressLZ4  CompressionAlgorithm = "lz4"
+)
+
+var UnknownCompression = errors.New("unknown compression algorithm")
+
+type compressionWrapper struct {
+	algorithm CompressionAlgorithm
+}
+
+// Returns StreamWrapper compressing stream with given algorithm
+func Compress(algorithm CompressionAlgorithm) StreamWrapper {
+	return compressionWrapper{algorithm}
+}
+
+func (c compressionWrapper) WrapWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.algorithm {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case CompressLZ4:
+		return lz4.NewWriter(w), nil
+	default:
+		return nil, UnknownCompression
+	}
+}
+
+func (c compressionWrapper) WrapReader(r io.Reader) (io.ReadCloser, error) {
+	switch c.algorithm {
+	case CompressGzip:
+		return gzip.NewReader(r)
+	case CompressZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressLZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	default:
+		return nil, UnknownCompression
+	}
+}
+
+const (
+	encryptionFrameSize = 64 * 1024
+	encryptionLastFrame = 1 << 31
+	encryptionKeySize   = 32
+)
+
+var (
+	BadEncryptionKey = errors.New("encryption key must be 32 bytes long")
+	TruncatedStream  = errors.New("encrypted stream is truncated")
+)
+
+type encryptionWrapper struct {
+	key []byte
+}
+
+// Returns StreamWrapper encrypting stream with AES-256-GCM. Stream is split
+// into frames, so memory usage does not depend on stream size, and
+// truncated or reordered streams are detected on decryption.
+func Encrypt(key []byte) (StreamWrapper, error) {
+	if len(key) != encryptionKeySize {
+		return nil, BadEncryptionKey
+	}
+
+	return encryptionWrapper{key}, nil
+}
+
+func (e encryptionWrapper) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (e encryptionWrapper) WrapWriter(w io.Writer) (io.WriteCloser, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		dest:   w,
+		aead:   aead,
+		nonce:  nonce,
+		buffer: make([]byte, 0, encryptionFrameSize),
+	}, nil
+}
+
+func (e encryptionWrapper) WrapReader(r io.Reader) (io.ReadCloser, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, TruncatedStream
+	}
+
+	return &decryptingReader{src: r, aead: aead, nonce: nonce}, nil
+}
+
+// Returns nonce for given frame, counter is mixed into last 8 bytes of
+// random stream nonce
+func frameNonce(nonce []byte, frame uint64) []byte {
+	result := make([]byte, len(nonce))
+	copy(result, nonce)
+
+	counter := binary.BigEndian.Uint64(result[len(result)-8:])
+	binary.BigEndian.PutUint64(result[len(result)-8:], counter^frame)
+
+	return result
+}
+
+// Last frame flag is authenticated, so stream can't be cut on frame border
+func frameHeader(length int, last bool) []byte {
+	header := make([]byte, 4)
+	value := uint32(length)
+	if last {
+		value |= encryptionLastFrame
+	}
+	binary.BigEndian.PutUint32(header, value)
+
+	return header
+}
+
+type encryptingWriter struct {
+	dest   io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	frame  uint64
+	buffer []byte
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		written += n
+		p = p[n:]
+
+		if len(w.buffer) == cap(w.buffer) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *encryptingWriter) flush(last bool) error {
+	sealedSize := len(w.buffer) + w.aead.Overhead()
+	header := frameHeader(sealedSize, last)
+
+	sealed := w.aead.Seal(
+		nil, frameNonce(w.nonce, w.frame), w.buffer, header,
+	)
+	w.frame++
+	w.buffer = w.buffer[:0]
+
+	if _, err := w.dest.Write(header); err != nil {
+		return err
+	}
+	_, err := w.dest.Write(sealed)
+
+	return err
+}
+
+func (w *encryptingWriter) Close() error {
+	return w.flush(true)
+}
+
+type decryptingReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	frame   uint64
+	current []byte
+	last    bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+
+	return n, nil
+}
+
+func (r *decryptingReader) next() error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r.src, header); err != nil {
+		return TruncatedStream
+	}
+
+	value := binary.BigEndian.Uint32(header)
+	last := value&encryptionLastFrame != 0
+	size := int(value &^ encryptionLastFrame)
+	if size > encryptionFrameSize+r.aead.Overhead() {
+		return errors.New("encrypted stream frame is too large")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return TruncatedStream
+	}
+
+	plain, err := r.aead.Open(
+		nil, frameNonce(r.nonce, r.frame), sealed, header,
+	)
+	if err != nil {
+		return errors.New("error decrypting stream: " + err.Error())
+	}
+
+	r.frame++
+	r.current = plain
+	r.last = last
+
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return nil
+}
+
+// Builds writer chain applying wrappers in given order. Returned closers
+// must be closed in order to flush all wrappers.
+func wrapWriter(
+	wrappers []StreamWrapper, dest io.Writer,
+) (io.Writer, []io.Closer, error) {
+	closers := []io.Closer{}
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		wrapped, err := wrappers[i].WrapWriter(dest)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		closers = append([]io.Closer{wrapped}, closers...)
+		dest = wrapped
+	}
+
+	return dest, closers, nil
+}
+
+// Builds reader chain reverting wrappers applied by wrapWriter
+func wrapReader(
+	wrappers []StreamWrapper, src io.Reader,
+) (io.Reader, []io.Closer, error) {
+	closers := []io.Closer{}
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		wrapped, err := wrappers[i].WrapReader(src)
+		if err != nil {
+			closeAll(closers)
+			return nil, nil, err
+		}
+
+		closers = append([]io.Closer{wrapped}, closers...)
+		src = wrapped
+	}
+
+	return src, closers, nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
@@ -0,0 +1,330 @@
+package zfs
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/theairkit/runcmd"
+)
+
+const (
+	defaultProgressInterval = time.Second
+	transferChunkSize       = 32 * 1024
+)
+
+// Controls how snapshot stream is copied from zfs send to destination
+type TransferOptions struct {
+	// Called periodically while stream is copied and once after transfer
+	// is finished. Total stream size is estimated with 'zfs send -nvP'.
+	Progress func(Progress)
+
+	// How often Progress is called, one second if not set
+	ProgressInterval time.Duration
+
+	// Bandwidth limit in bytes per second, zero means unlimited
+	RateLimit int64
+
+	// Size of buffer between sender and receiver in bytes, used to smooth
+	// bursty zfs send output. Zero means no buffering.
+	BufferSize int
+
+	// Stream transformations like compression or encryption, applied in
+	// given order when stream is written by SendStream* and reverted in
+	// ReceiveStream. Streams sent directly to ZfsEntry are not wrapped.
+	Wrappers []StreamWrapper
+}
+
+// Transfer progress passed to TransferOptions.Progress
+type Progress struct {
+	// Bytes of zfs stream copied
+	Bytes int64
+
+	// Bytes after stream wrappers, equals to Bytes without wrappers
+	WireBytes int64
+
+	// Estimated stream size in bytes
+	Total int64
+
+	// Average rate in bytes per second
+	Rate float64
+
+	// Estimated time left, zero if unknown
+	ETA time.Duration
+
+	Elapsed time.Duration
+}
+
+// Returns ratio of zfs stream size to wrapped stream size
+func (p Progress) CompressionRatio() float64 {
+	if p.WireBytes == 0 {
+		return 1
+	}
+
+	return float64(p.Bytes) / float64(p.WireBytes)
+}
+
+func (o TransferOptions) copy(
+	dest io.Writer, src io.Reader, total int64,
+) (int64, error) {
+	wire := &wireWriter{dest: dest, limit: o.RateLimit, start: time.Now()}
+
+	out, closers, err := wrapWriter(o.Wrappers, wire)
+	if err != nil {
+		return 0, err
+	}
+
+	return o.transfer(out, src, total, closers, func() int64 {
+		return wire.written
+	})
+}
+
+func (o TransferOptions) transfer(
+	dest io.Writer, src io.Reader, total int64,
+	closers []io.Closer, wireBytes func() int64,
+) (int64, error) {
+	if o.BufferSize > 0 {
+		buffered := newBufferedReader(src, o.BufferSize)
+		defer buffered.Close()
+		src = buffered
+	}
+
+	interval := o.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	w := &transferWriter{
+		dest:      dest,
+		opts:      o,
+		interval:  interval,
+		total:     total,
+		wireBytes: wireBytes,
+		start:     time.Now(),
+	}
+
+	n, err := io.Copy(w, src)
+	for _, closer := range closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	w.report()
+
+	return n, err
+}
+
+// Decodes stream written by SendStream* with given options and receives it
+// into given entry
+func ReceiveStream(to ZfsEntry, src io.Reader, opts TransferOptions) error {
+	counter := &countingReader{src: src}
+
+	decoded, closers, err := wrapReader(opts.Wrappers, counter)
+	if err != nil {
+		return err
+	}
+
+	rc, stdinPipe, err := to.Receive()
+	if err != nil {
+		closeAll(closers)
+		return err
+	}
+
+	wire := &wireWriter{
+		dest:  stdinPipe,
+		limit: opts.RateLimit,
+		start: time.Now(),
+	}
+	_, err = opts.transfer(wire, decoded, 0, closers, counter.count)
+	if err != nil {
+		err = errors.New("error copying to receive: " + err.Error())
+	}
+
+	return finishReceive(rc, stdinPipe, err)
+}
+
+// Closes receive stdin and waits for receive to exit, on truncated stream
+// it fails and exits too. Returns copy error combined with receive error.
+func finishReceive(
+	rc runcmd.CmdWorker, stdinPipe io.Closer, copyErr error,
+) error {
+	closeErr := stdinPipe.Close()
+	waitErr := parseError(rc.Wait(), nil)
+
+	switch {
+	case copyErr != nil && waitErr != nil:
+		return errors.New(copyErr.Error() + "; receive: " + waitErr.Error())
+	case copyErr != nil:
+		return copyErr
+	case waitErr != nil:
+		return waitErr
+	default:
+		return closeErr
+	}
+}
+
+type transferWriter struct {
+	dest      io.Writer
+	opts      TransferOptions
+	interval  time.Duration
+	wireBytes func() int64
+
+	total      int64
+	written    int64
+	start      time.Time
+	lastReport time.Time
+}
+
+func (w *transferWriter) Write(p []byte) (int, error) {
+	n, err := w.dest.Write(p)
+	w.written += int64(n)
+
+	if w.opts.Progress != nil && time.Since(w.lastReport) >= w.interval {
+		w.report()
+	}
+
+	return n, err
+}
+
+func (w *transferWriter) report() {
+	if w.opts.Progress == nil {
+		return
+	}
+
+	w.lastReport = time.Now()
+	w.opts.Progress(w.progress())
+}
+
+func (w *transferWriter) progress() Progress {
+	p := Progress{
+		Bytes:     w.written,
+		WireBytes: w.wireBytes(),
+		Total:     w.total,
+		Elapsed:   time.Since(w.start),
+	}
+
+	if p.Elapsed > 0 {
+		p.Rate = float64(p.Bytes) / p.Elapsed.Seconds()
+	}
+
+	if p.Rate > 0 && p.Total > p.Bytes {
+		p.ETA = time.Duration(
+			float64(p.Total-p.Bytes) / p.Rate * float64(time.Second),
+		)
+	}
+
+	return p
+}
+
+// Counts bytes written to destination and applies rate limit
+type wireWriter struct {
+	dest    io.Writer
+	limit   int64
+	start   time.Time
+	written int64
+}
+
+func (w *wireWriter) Write(p []byte) (int, error) {
+	n, err := w.dest.Write(p)
+	w.written += int64(n)
+
+	if w.limit > 0 {
+		expected := time.Duration(
+			float64(w.written) / float64(w.limit) * float64(time.Second),
+		)
+		if elapsed := time.Since(w.start); elapsed < expected {
+			time.Sleep(expected - elapsed)
+		}
+	}
+
+	return n, err
+}
+
+type countingReader struct {
+	src  io.Reader
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	atomic.AddInt64(&r.read, int64(n))
+	return n, err
+}
+
+// Safe to call while reader is used by buffering goroutine
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.read)
+}
+
+// Reads source in background goroutine, keeping up to size bytes ahead
+// of consumer
+type bufferedReader struct {
+	chunks  chan []byte
+	current []byte
+	err     error
+	done    chan struct{}
+}
+
+func newBufferedReader(src io.Reader, size int) *bufferedReader {
+	chunkSize := transferChunkSize
+	if size < chunkSize {
+		chunkSize = size
+	}
+
+	b := &bufferedReader{
+		chunks: make(chan []byte, size/chunkSize),
+		done:   make(chan struct{}),
+	}
+
+	go b.fill(src, chunkSize)
+
+	return b
+}
+
+func (b *bufferedReader) fill(src io.Reader, chunkSize int) {
+	defer close(b.chunks)
+
+	for {
+		chunk := make([]byte, chunkSize)
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			select {
+			case b.chunks <- chunk[:n]:
+			case <-b.done:
+				return
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			b.err = err
+			return
+		}
+	}
+}
+
+func (b *bufferedReader) Read(p []byte) (int, error) {
+	if len(b.current) == 0 {
+		chunk, ok := <-b.chunks
+		if !ok {
+			if b.err != nil {
+				return 0, b.err
+			}
+			return 0, io.EOF
+		}
+		b.current = chunk
+	}
+
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+
+	return n, nil
+}
+
+func (b *bufferedReader) Close() error {
+	close(b.done)
+	return nil
+}
@@ -0,0 +1,188 @@
+package zfs
+
+import (
+	"errors"
+	"sort"
+)
+
+// Returned by Walk callback to skip children of current node
+var SkipChildren = errors.New("skip children")
+
+// Filesystem or volume in dataset tree
+type TreeNode struct {
+	Dataset
+
+	parent    *TreeNode
+	children  []*TreeNode
+	snapshots []Snapshot
+}
+
+// Returns parent node, nil for tree root
+func (n *TreeNode) Parent() *TreeNode {
+	return n.parent
+}
+
+// Returns child filesystems and volumes sorted by path
+func (n *TreeNode) Children() []*TreeNode {
+	return n.children
+}
+
+// Returns snapshots in order listed by zfs
+func (n *TreeNode) Snapshots() []Snapshot {
+	return n.snapshots
+}
+
+// Walks node and its descendents depth-first, parents before children.
+// Walk stops on first error returned by fn, except SkipChildren.
+func (n *TreeNode) Walk(fn func(*TreeNode) error) error {
+	err := fn(n)
+	if err == SkipChildren {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, child := range n.children {
+		if err := child.Walk(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Walks node and its descendents level by level
+func (n *TreeNode) WalkBreadthFirst(fn func(*TreeNode) error) error {
+	queue := []*TreeNode{n}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		err := fn(node)
+		if err == SkipChildren {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		queue = append(queue, node.children...)
+	}
+
+	return nil
+}
+
+type Tree struct {
+	Root  *TreeNode
+	nodes map[string]*TreeNode
+}
+
+// Returns node of filesystem or volume with given path
+func (t *Tree) Find(path string) (*TreeNode, bool) {
+	node, ok := t.nodes[path]
+	return node, ok
+}
+
+// See Zfs.LoadTree
+func LoadTree(path string, props ...string) (*Tree, error) {
+	return std().LoadTree(path, props...)
+}
+
+// Loads dataset with all descendents and snapshots using one zfs list
+// call. Given properties are fetched for every dataset.
+func (z Zfs) LoadTree(path string, props ...string) (*Tree, error) {
+	datasets, err := z.List(ListOptions{
+		Paths: []string{path},
+		Types: []DatasetType{
+			TypeFilesystem, TypeVolume, TypeSnapshot,
+		},
+		Recursive:  true,
+		Properties: props,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &Tree{nodes: map[string]*TreeNode{}}
+	for _, dataset := range datasets {
+		if dataset.Type != TypeSnapshot {
+			tree.nodes[dataset.getPath()] = &TreeNode{Dataset: dataset}
+		}
+	}
+
+	root, ok := tree.nodes[path]
+	if !ok {
+		return nil, errors.New(
+			"cannot open '" + path + "': dataset does not exist",
+		)
+	}
+	tree.Root = root
+
+	for _, dataset := range datasets {
+		parentPath, ok := ParentPath(dataset.getPath())
+		if !ok || dataset.getPath() == path {
+			continue
+		}
+
+		parent, ok := tree.nodes[parentPath]
+		if !ok {
+			continue
+		}
+
+		if snap, ok := dataset.ZfsEntry.(Snapshot); ok {
+			parent.snapshots = append(parent.snapshots, snap)
+			continue
+		}
+
+		node := tree.nodes[dataset.getPath()]
+		node.parent = parent
+		parent.children = append(parent.children, node)
+	}
+
+	for _, node := range tree.nodes {
+		children := node.children
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].getPath() < children[j].getPath()
+		})
+	}
+
+	return tree, nil
+}
+
+// Returns parent filesystem, false for pool
+func (f Fs) Parent() (Fs, bool) {
+	parent, ok := ParentPath(f.Path)
+	if !ok {
+		return Fs{}, false
+	}
+
+	return f.runner.NewFs(parent), true
+}
+
+// Returns child filesystems, all descendents if recursive is set
+func (f Fs) Children(recursive bool) ([]Fs, error) {
+	opts := ListOptions{
+		Paths: []string{f.Path},
+		Types: []DatasetType{TypeFilesystem},
+		Depth: 1,
+	}
+	if recursive {
+		opts.Depth = 0
+		opts.Recursive = true
+	}
+
+	datasets, err := f.runner.List(opts)
+	if err != nil {
+		return []Fs{}, err
+	}
+
+	children := []Fs{}
+	for _, dataset := range datasets {
+		if dataset.getPath() != f.Path {
+			children = append(children, f.runner.NewFs(dataset.getPath()))
+		}
+	}
+
+	return children, nil
+}
@@ -0,0 +1,123 @@
+package zfs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Space consumed by user, group or project, as reported by zfs userspace
+type SpaceUsage struct {
+	// POSIX User, POSIX Group, SMB User, SMB Group or Project
+	Type string
+	Name string
+
+	// Bytes used and quota, zero quota means none
+	Used  int64
+	Quota int64
+
+	// Objects used and object quota
+	ObjUsed  int64
+	ObjQuota int64
+}
+
+type SpaceOptions struct {
+	// Print numeric ids instead of names (-n), projects always have
+	// numeric ids
+	NumericIDs bool
+
+	// Identity types to list (-t): posixuser, smbuser, posixgroup,
+	// smbgroup or all. Not supported by ProjectSpace.
+	Types []string
+}
+
+func (o SpaceOptions) args(command string) []string {
+	if command == "projectspace" {
+		return []string{
+			command, "-Hp", "-o", "name,used,quota,objused,objquota",
+		}
+	}
+
+	args := []string{
+		command, "-Hp", "-o", "type,name,used,quota,objused,objquota",
+	}
+
+	if o.NumericIDs {
+		args = append(args, "-n")
+	}
+	if len(o.Types) > 0 {
+		args = append(args, "-t", strings.Join(o.Types, ","))
+	}
+
+	return args
+}
+
+// Returns space used by users of filesystem
+func (f Fs) UserSpace(opts SpaceOptions) ([]SpaceUsage, error) {
+	return f.spaceUsage(opts.args("userspace"), true)
+}
+
+// Returns space used by groups of filesystem
+func (f Fs) GroupSpace(opts SpaceOptions) ([]SpaceUsage, error) {
+	return f.spaceUsage(opts.args("groupspace"), true)
+}
+
+// Returns space used by projects of filesystem, projects are always
+// identified by numeric ids
+func (f Fs) ProjectSpace(opts SpaceOptions) ([]SpaceUsage, error) {
+	if len(opts.Types) > 0 {
+		return []SpaceUsage{}, errors.New("projectspace doesn't support types")
+	}
+
+	return f.spaceUsage(opts.args("projectspace"), false)
+}
+
+func (f Fs) spaceUsage(args []string, withType bool) ([]SpaceUsage, error) {
+	c := f.runner.Command("zfs", append(args, f.Path)...)
+
+	stdout, stderr, err := c.Output()
+	if err != nil {
+		return []SpaceUsage{}, parseError(err, stderr)
+	}
+
+	return parseSpaceUsage(string(stdout), withType)
+}
+
+func parseSpaceUsage(output string, withType bool) ([]SpaceUsage, error) {
+	usages := []SpaceUsage{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if !withType {
+			fields = append([]string{"Project"}, fields...)
+		}
+		if len(fields) != 6 {
+			return usages, errors.New("unexpected zfs userspace output: " + line)
+		}
+
+		usage := SpaceUsage{Type: fields[0], Name: fields[1]}
+		values := []*int64{
+			&usage.Used, &usage.Quota, &usage.ObjUsed, &usage.ObjQuota,
+		}
+		for i, value := range values {
+			field := fields[i+2]
+			if field == "-" {
+				continue
+			}
+
+			size, err := ParseSize(field)
+			if err != nil {
+				return usages, errors.New(
+					"error parsing zfs userspace output: " + err.Error(),
+				)
+			}
+			*value = size
+		}
+
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
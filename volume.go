@@ -0,0 +1,15 @@
+package zfs
+
+type Volume struct {
+	zfsEntryBase
+}
+
+// See Zfs.NewVolume
+func NewVolume(volumePath string) Volume {
+	return std().NewVolume(volumePath)
+}
+
+// Return Volume wrapper without any checks and actualy creation
+func (z Zfs) NewVolume(volumePath string) Volume {
+	return Volume{zfsEntryBase{z, volumePath}}
+}
@@ -1,6 +1,11 @@
 package zfs
 
-import "github.com/theairkit/runcmd"
+import (
+	"sync"
+	"time"
+
+	"github.com/theairkit/runcmd"
+)
 
 type Zfs struct {
 	*ZfsRunner
@@ -8,37 +13,124 @@ type Zfs struct {
 
 type ZfsRunner struct {
 	runcmd.Runner
-	sudo bool
+	escalation Escalation
+	kind       RunnerKind
+
+	observer  Observer
+	redactors []Redactor
+
+	timeout time.Duration
+
+	// executable paths by command name, like zfs or zpool
+	binaries map[string]string
+
+	// variables set and unset for every command
+	env      map[string]string
+	unsetEnv []string
+
+	capabilities *capabilitiesCache
+	format       OutputFormat
+	retry        RetryPolicy
+
+	// set for dry-run copies
+	plan *Plan
+
+	// guards settings of standard runner, which may be changed by SetStd*
+	// functions while its objects are used
+	mutex *sync.RWMutex
 }
 
-var std = mustCreateRunner(NewZfsLocal(false))
+func (z *ZfsRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	runner := z.current()
+	if runner.plan != nil && isMutating(name, args) {
+		return runner.planned(name, args)
+	}
 
-func (z ZfsRunner) Command(name string, args ...string) runcmd.CmdWorker {
-	if z.sudo {
-		args = append([]string{name}, args...)
-		name = "sudo"
+	return runner.command(name, args)
+}
+
+// Returns copy of runner settings, taken under lock for standard runner
+func (z *ZfsRunner) current() ZfsRunner {
+	if z.mutex == nil {
+		return *z
 	}
 
-	return z.Runner.Command(name, args...)
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	return *z
+}
+
+func (z ZfsRunner) command(name string, args []string) runcmd.CmdWorker {
+	worker := z.worker(name, args)
+	if !z.retries(name, args) {
+		return worker
+	}
+
+	runName, runArgs := z.argv(name, args)
+
+	return retryWorker{
+		CmdWorker: worker,
+		runner:    z,
+		argv:      append([]string{runName}, runArgs...),
+		destroy:   args[0] == "destroy",
+		create: func() runcmd.CmdWorker {
+			return z.worker(name, args)
+		},
+	}
+}
+
+func (z ZfsRunner) worker(name string, args []string) runcmd.CmdWorker {
+	timed := z.timed(name, args)
+	name, args = z.argv(name, args)
+
+	// observer is outside of timeout, so it is called synchronously and
+	// sees TimeoutError
+	process := z.Runner.Command(name, args...)
+	worker := process
+	if timed {
+		worker = timeoutWorker{worker, process, z.timeout}
+	}
+	worker = z.observe(worker, name, args)
+	if !z.escalation.IsNone() {
+		worker = &escalatedWorker{CmdWorker: worker, escalation: z.escalation}
+	}
+
+	return worker
+}
+
+// Returns command line which is actually run: binary path, environment
+// and escalation applied
+func (z ZfsRunner) argv(name string, args []string) (string, []string) {
+	if binary, ok := z.binaries[name]; ok {
+		name = binary
+	}
+
+	name, args = z.escalation.wrap(name, args)
+
+	return z.envArgs(name, args)
 }
 
 func NewZfsLocal(sudo bool) (Zfs, error) {
 	runner, err := runcmd.NewLocalRunner()
-	return Zfs{&ZfsRunner{runner, sudo}}, err
+	return Zfs{&ZfsRunner{
+		Runner:       runner,
+		escalation:   escalationFor(sudo),
+		kind:         RunnerLocal,
+		capabilities: &capabilitiesCache{},
+	}}, err
 }
 
 func NewZfs(runner runcmd.Runner, sudo bool) Zfs {
-	return Zfs{&ZfsRunner{runner, sudo}}
+	return Zfs{&ZfsRunner{
+		Runner:       runner,
+		escalation:   escalationFor(sudo),
+		kind:         runnerKind(runner),
+		capabilities: &capabilitiesCache{},
+	}}
 }
 
+// Enables non-interactive sudo for standard runner, see SetStdEscalation
 func SetStdSudo(sudo bool) {
-	std.sudo = sudo
-}
-
-func mustCreateRunner(operator Zfs, err error) *Zfs {
-	if err != nil {
-		panic(err)
-	}
-
-	return &operator
+	SetStdEscalation(escalationFor(sudo))
 }
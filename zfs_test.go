@@ -1,10 +1,18 @@
 package zfs
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/theairkit/runcmd"
 )
@@ -171,10 +179,19 @@ func TestSnapshot(t *testing.T) {
 		t.Error("[Snapshot] snapshot not created")
 	}
 
-	if ok, _ := NewSnapshot(testPath + "/fs1@s1").Exists(); !ok {
+	s2, err := NewSnapshot(testPath + "/fs1@s1")
+	if err != nil {
+		t.Error("[Snapshot] NewSnapshot error:", err)
+	}
+	if ok, _ := s2.Exists(); !ok {
 		t.Error("[Snapshot] NewSnapshot not works...")
 	}
 
+	_, err = NewSnapshot(testPath + "/fs1")
+	if err == nil || !InvalidDataset.MatchString(err.Error()) {
+		t.Error("[Snapshot] wrong error for NewSnapshot without '@':", err)
+	}
+
 	_, err = NewFs(unicorn).Snapshot("s2")
 	if err == nil {
 		t.Error("[Snapshot] created snapshot on not existent fs")
@@ -637,7 +654,7 @@ func TestSendReceive(t *testing.T) {
 			destSize, srcSize)
 	}
 
-	destSnap := NewSnapshot(destFs.Path + "@s1")
+	destSnap, _ := NewSnapshot(destFs.Path + "@s1")
 	if ok, _ := destSnap.Exists(); !ok {
 		t.Error("[SndRcv] destination snapshot fs doesn't exists")
 	}
@@ -648,7 +665,7 @@ func TestSendReceive(t *testing.T) {
 		t.Error("[SndRcv] error sending incremental snapshot:", err)
 	}
 
-	destSnap = NewSnapshot(destFs.Path + "@s2")
+	destSnap, _ = NewSnapshot(destFs.Path + "@s2")
 	if ok, _ := destSnap.Exists(); !ok {
 		t.Error("[SndRcv] destination snapshot fs doesn't exists after incremental")
 	}
@@ -657,7 +674,7 @@ func TestSendReceive(t *testing.T) {
 	destFs.Destroy(RF_Hard)
 
 	fmt.Println("Sending not existing fs")
-	srcSnap = NewSnapshot(unicorn + "@s1")
+	srcSnap, _ = NewSnapshot(unicorn + "@s1")
 	err = srcSnap.Send(destFs)
 	if err == nil {
 		t.Error("[SndRcv] sended not existent snapshot without errors")
@@ -719,3 +736,1720 @@ func TestRemote(t *testing.T) {
 
 	fs.Destroy(RF_No)
 }
+
+func TestTransferOptions(t *testing.T) {
+	data := bytes.Repeat([]byte("zfs"), 100000)
+
+	var last Progress
+	calls := 0
+	opts := TransferOptions{
+		Progress: func(p Progress) {
+			calls++
+			last = p
+		},
+		ProgressInterval: time.Millisecond,
+		RateLimit:        1000000,
+		BufferSize:       4096,
+	}
+
+	dest := &bytes.Buffer{}
+	started := time.Now()
+	n, err := opts.copy(dest, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal("[TransferOptions] error copying:", err)
+	}
+
+	if n != int64(len(data)) || !bytes.Equal(dest.Bytes(), data) {
+		t.Errorf("[TransferOptions] copied %d bytes, want %d", n, len(data))
+	}
+
+	if elapsed := time.Since(started); elapsed < 250*time.Millisecond {
+		t.Errorf("[TransferOptions] rate limit not applied, took %s", elapsed)
+	}
+
+	if calls == 0 {
+		t.Fatal("[TransferOptions] progress callback not called")
+	}
+	if last.Bytes != int64(len(data)) || last.Total != int64(len(data)) {
+		t.Errorf("[TransferOptions] wrong final progress: %+v", last)
+	}
+}
+
+// Runner which commands write to stdout until it is closed
+type streamingRunner struct{}
+
+func (streamingRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	return &streamingWorker{done: make(chan struct{})}
+}
+
+type streamingWorker struct {
+	runcmd.CmdWorker
+	stdout *io.PipeWriter
+	reader *io.PipeReader
+	done   chan struct{}
+}
+
+func (w *streamingWorker) StdoutPipe() (io.Reader, error) {
+	w.reader, w.stdout = io.Pipe()
+	return w.reader, nil
+}
+
+func (w *streamingWorker) Start() error {
+	go func() {
+		defer close(w.done)
+		for {
+			if _, err := w.stdout.Write(make([]byte, 1024)); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *streamingWorker) Wait() error {
+	<-w.done
+	return errors.New("exit status 1")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write |1: broken pipe")
+}
+
+func TestSendStreamFailure(t *testing.T) {
+	z := NewZfs(streamingRunner{}, false)
+	snap, _ := z.NewSnapshot("tank/fs@s1")
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- snap.sendStream(failingWriter{}, "send", snap.Path)
+	}()
+
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "; send: exit status 1") {
+			t.Error("[SendStreamFailure] send error not combined:", err)
+		}
+		if !DefaultRetryPolicy().retryable(err.Error()) {
+			t.Error("[SendStreamFailure] broken pipe not retryable:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("[SendStreamFailure] failed send not waited")
+	}
+}
+
+func TestSendOptionsArgs(t *testing.T) {
+	snap, _ := NewSnapshot(testPath + "@s2")
+	base, _ := NewSnapshot(testPath + "@s1")
+
+	args := SendOptions{Props: true, Raw: true}.args(snap, nil)
+	want := []string{"send", "-p", "-w", testPath + "@s2"}
+	if fmt.Sprint(args) != fmt.Sprint(want) {
+		t.Errorf("[SendOptionsArgs] wrong args %v, want %v", args, want)
+	}
+
+	args = SendOptions{Intermediary: true}.args(snap, &base)
+	want = []string{"send", "-I", testPath + "@s1", testPath + "@s2"}
+	if fmt.Sprint(args) != fmt.Sprint(want) {
+		t.Errorf("[SendOptionsArgs] wrong args %v, want %v", args, want)
+	}
+}
+
+func TestEstimateSendSize(t *testing.T) {
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[EstimateSendSize] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	snap, err := fs.Snapshot("s1")
+	if err != nil {
+		t.Fatal("[EstimateSendSize] error creating snapshot:", err)
+	}
+
+	size, err := snap.EstimateSendSize(nil, SendOptions{})
+	if err != nil {
+		t.Fatal("[EstimateSendSize] error estimating size:", err)
+	}
+	if size <= 0 {
+		t.Error("[EstimateSendSize] wrong estimated size:", size)
+	}
+
+	_, err = snap.CheckSendSpace(nil, NewFs(testPath+"/fs2/dest"), SendOptions{})
+	if err != nil {
+		t.Error("[EstimateSendSize] error checking space:", err)
+	}
+}
+
+func TestArchiveChunks(t *testing.T) {
+	storage, err := NewDirStorage(t.TempDir())
+	if err != nil {
+		t.Fatal("[ArchiveChunks] error creating storage:", err)
+	}
+
+	data := bytes.Repeat([]byte("stream"), 1000)
+	w := &chunkWriter{storage: storage, name: "archive", chunkSize: 1024}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal("[ArchiveChunks] error writing chunks:", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("[ArchiveChunks] error closing chunks:", err)
+	}
+
+	if len(w.chunks) != 6 || w.total != int64(len(data)) {
+		t.Fatalf("[ArchiveChunks] wrong chunks: %d chunks, %d bytes",
+			len(w.chunks), w.total)
+	}
+
+	manifest := ArchiveManifest{Chunks: w.chunks, Size: w.total}
+	err = writeArchiveManifest(storage, "archive", manifest)
+	if err != nil {
+		t.Fatal("[ArchiveChunks] error writing manifest:", err)
+	}
+
+	if _, err := VerifyArchive(storage, "archive"); err != nil {
+		t.Error("[ArchiveChunks] error verifying archive:", err)
+	}
+
+	restored := &bytes.Buffer{}
+	if err := readArchive(storage, manifest, restored); err != nil {
+		t.Error("[ArchiveChunks] error reading archive:", err)
+	}
+	if !bytes.Equal(restored.Bytes(), data) {
+		t.Error("[ArchiveChunks] restored stream differs from original")
+	}
+
+	f, _ := storage.Create(w.chunks[2].Name)
+	f.Write([]byte("corrupted"))
+	f.Close()
+
+	if _, err := VerifyArchive(storage, "archive"); err == nil {
+		t.Error("[ArchiveChunks] corrupted archive verified")
+	}
+
+	z := NewZfs(scriptedRunner{outputs: map[string]string{
+		"zfs list -H -o name tank/fs@a":       "tank/fs@a\n",
+		"zfs get -Hp -o value guid tank/fs@a": "42\n",
+		"zfs send tank/fs@a":                  "plain stream",
+	}, calls: map[string]int{}}, false)
+	snap, _ := z.NewSnapshot("tank/fs@a")
+	snap = snap.WithTransfer(TransferOptions{
+		Wrappers: []StreamWrapper{Compress(CompressGzip)},
+	})
+
+	manifest, err = snap.Archive(nil, SendOptions{}, storage, "wrapped", 0)
+	if err != nil {
+		t.Fatal("[ArchiveChunks] error archiving snapshot:", err)
+	}
+	restored.Reset()
+	if err := readArchive(storage, manifest, restored); err != nil ||
+		restored.String() != "plain stream" {
+		t.Errorf("[ArchiveChunks] archived stream is wrapped: %q, %v",
+			restored, err)
+	}
+
+	runner := scriptedRunner{outputs: map[string]string{
+		"zfs list -H -o name tank/fs@a":       "tank/fs@a\n",
+		"zfs get -Hp -o value guid tank/fs@a": "42\n",
+		"zfs send -nvP tank/fs@a":             "size\t12\n",
+	}, calls: map[string]int{}}
+	dry, plan := NewZfs(runner, false).DryRun()
+	snap, _ = dry.NewSnapshot("tank/fs@a")
+
+	_, err = snap.Archive(nil, SendOptions{}, storage, "planned", 0)
+	if err != nil || plan.Sent() != 12 {
+		t.Errorf("[ArchiveChunks] archive not planned: %d, %v", plan.Sent(), err)
+	}
+	if _, err := ReadArchiveManifest(storage, "planned"); err == nil {
+		t.Error("[ArchiveChunks] manifest written in dry-run mode")
+	}
+}
+
+func TestStreamWrappers(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encrypt, err := Encrypt(key)
+	if err != nil {
+		t.Fatal("[StreamWrappers] error creating encryption:", err)
+	}
+
+	data := bytes.Repeat([]byte("compressible zfs stream "), 20000)
+	for _, wrappers := range [][]StreamWrapper{
+		{Compress(CompressGzip)},
+		{Compress(CompressZstd), encrypt},
+	} {
+		var last Progress
+		opts := TransferOptions{
+			Progress: func(p Progress) { last = p },
+			Wrappers: wrappers,
+		}
+
+		wire := &bytes.Buffer{}
+		_, err := opts.copy(wire, bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal("[StreamWrappers] error writing stream:", err)
+		}
+
+		if last.CompressionRatio() <= 1 {
+			t.Errorf("[StreamWrappers] wrong compression ratio: %+v", last)
+		}
+
+		decoded, closers, err := wrapReader(wrappers, wire)
+		if err != nil {
+			t.Fatal("[StreamWrappers] error decoding stream:", err)
+		}
+		restored := &bytes.Buffer{}
+		_, err = restored.ReadFrom(decoded)
+		closeAll(closers)
+		if err != nil {
+			t.Fatal("[StreamWrappers] error reading stream:", err)
+		}
+
+		if !bytes.Equal(restored.Bytes(), data) {
+			t.Error("[StreamWrappers] decoded stream differs from original")
+		}
+	}
+
+	wire := &bytes.Buffer{}
+	_, err = TransferOptions{Wrappers: []StreamWrapper{encrypt}}.copy(
+		wire, bytes.NewReader(data), 0,
+	)
+	if err != nil {
+		t.Fatal("[StreamWrappers] error encrypting stream:", err)
+	}
+
+	truncated := bytes.NewReader(wire.Bytes()[:wire.Len()/2])
+	decoded, _, _ := wrapReader([]StreamWrapper{encrypt}, truncated)
+	_, err = (&bytes.Buffer{}).ReadFrom(decoded)
+	if err != TruncatedStream {
+		t.Error("[StreamWrappers] wrong error reading truncated stream:", err)
+	}
+}
+
+// Builds stream record the same way zfs does, updating running checksum
+func dumpStreamRecord(
+	stream *bytes.Buffer, sum *fletcher4,
+	kind StreamRecordType, union []byte, payload []byte,
+) {
+	record := make([]byte, streamRecordSize)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(kind))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	copy(record[8:], union)
+
+	sum.update(binary.LittleEndian, record[:streamChecksumOffset])
+	if kind != DRR_BEGIN {
+		for i, value := range sum {
+			binary.LittleEndian.PutUint64(
+				record[streamChecksumOffset+i*8:], value,
+			)
+		}
+	}
+	sum.update(binary.LittleEndian, record[streamChecksumOffset:])
+	sum.update(binary.LittleEndian, payload)
+
+	stream.Write(record)
+	stream.Write(payload)
+}
+
+func TestInspectStream(t *testing.T) {
+	stream := &bytes.Buffer{}
+	sum := fletcher4{}
+
+	begin := make([]byte, 304)
+	binary.LittleEndian.PutUint64(begin[0:8], streamMagic)
+	binary.LittleEndian.PutUint64(
+		begin[8:16], uint64(StreamFeatureRaw)<<2|streamSubstream,
+	)
+	binary.LittleEndian.PutUint64(begin[32:40], 42)
+	binary.LittleEndian.PutUint64(begin[40:48], 24)
+	copy(begin[48:], testPath+"@s2")
+	dumpStreamRecord(stream, &sum, DRR_BEGIN, begin, nil)
+
+	write := make([]byte, 304)
+	binary.LittleEndian.PutUint64(write[24:32], 16)
+	dumpStreamRecord(stream, &sum, DRR_WRITE, write, bytes.Repeat([]byte{1}, 16))
+
+	end := make([]byte, 304)
+	for i, value := range sum {
+		binary.LittleEndian.PutUint64(end[i*8:], value)
+	}
+	dumpStreamRecord(stream, &sum, DRR_END, end, nil)
+
+	header, err := ReadStreamHeader(bytes.NewReader(stream.Bytes()))
+	if err != nil {
+		t.Fatal("[InspectStream] error reading header:", err)
+	}
+	if header.Name != testPath+"@s2" || header.ToGUID != 42 ||
+		!header.Incremental() || !header.Raw() || header.Compressed() {
+		t.Errorf("[InspectStream] wrong header: %+v", header)
+	}
+
+	info, err := InspectStream(bytes.NewReader(stream.Bytes()))
+	if err != nil {
+		t.Fatal("[InspectStream] error inspecting stream:", err)
+	}
+	if info.Records[DRR_WRITE] != 1 || info.Size != int64(stream.Len()) {
+		t.Errorf("[InspectStream] wrong stream info: %+v", info)
+	}
+
+	corrupted := append([]byte{}, stream.Bytes()...)
+	corrupted[streamRecordSize+streamRecordSize+4] ^= 0xff
+	_, err = InspectStream(bytes.NewReader(corrupted))
+	if err == nil {
+		t.Error("[InspectStream] corrupted stream inspected without errors")
+	}
+
+	_, err = ReadStreamHeader(bytes.NewReader(make([]byte, streamRecordSize)))
+	if err != BadStreamMagic {
+		t.Error("[InspectStream] wrong error reading bad stream:", err)
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	opts := ListOptions{
+		Paths:      []string{testPath},
+		Types:      []DatasetType{TypeFilesystem, TypeSnapshot},
+		Depth:      1,
+		SortDesc:   []string{"creation"},
+		Properties: []string{"used", "origin"},
+	}
+
+	args := opts.args()
+	want := []string{
+		"list", "-Hp", "-d", "1", "-t", "filesystem,snapshot",
+		"-S", "creation", "-o", "name,type,used,origin", testPath,
+	}
+	if fmt.Sprint(args) != fmt.Sprint(want) {
+		t.Errorf("[ListOptions] wrong args %v, want %v", args, want)
+	}
+
+	output := testPath + "\tfilesystem\t1024\t-\n" +
+		testPath + "@s1\tsnapshot\t0\t-\n" +
+		testPath + "/vol\tvolume\t2048\t-\n"
+
+	datasets, err := std().parseList(output, opts.Properties)
+	if err != nil {
+		t.Fatal("[ListOptions] error parsing list:", err)
+	}
+	if len(datasets) != 3 {
+		t.Fatalf("[ListOptions] wrong datasets count: %d", len(datasets))
+	}
+
+	if _, ok := datasets[0].ZfsEntry.(Fs); !ok {
+		t.Errorf("[ListOptions] wrong entry type %T", datasets[0].ZfsEntry)
+	}
+	if snap, ok := datasets[1].ZfsEntry.(Snapshot); !ok || snap.Name != "s1" {
+		t.Errorf("[ListOptions] wrong snapshot entry %#v", datasets[1].ZfsEntry)
+	}
+	if _, ok := datasets[2].ZfsEntry.(Volume); !ok {
+		t.Errorf("[ListOptions] wrong entry type %T", datasets[2].ZfsEntry)
+	}
+
+	used, err := datasets[2].PropertyInt("used")
+	if err != nil || used != 2048 {
+		t.Errorf("[ListOptions] wrong used property: %d, %v", used, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[List] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+	fs.Snapshot("s1")
+
+	datasets, err := List(ListOptions{
+		Paths:      []string{fs.Path},
+		Types:      []DatasetType{TypeAll},
+		Recursive:  true,
+		Properties: []string{"used"},
+	})
+	if err != nil {
+		t.Fatal("[List]", err)
+	}
+
+	if len(datasets) != 2 {
+		t.Fatalf("[List] wrong datasets count: %d", len(datasets))
+	}
+	if datasets[1].Type != TypeSnapshot || datasets[1].getPath() != fs.Path+"@s1" {
+		t.Errorf("[List] wrong snapshot: %+v", datasets[1])
+	}
+}
+
+func TestCloneGraph(t *testing.T) {
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[CloneGraph] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	sn, err := fs.Snapshot("s1")
+	if err != nil {
+		t.Fatal("[CloneGraph] error creating snapshot:", err)
+	}
+
+	clone, err := sn.Clone(testPath + "/cln1")
+	if err != nil {
+		t.Fatal("[CloneGraph] error creating clone:", err)
+	}
+	defer clone.Destroy(RF_Hard)
+
+	origin, err := clone.Origin()
+	if err != nil {
+		t.Fatal("[CloneGraph] error getting origin:", err)
+	}
+	if origin == nil || origin.Path != sn.Path {
+		t.Errorf("[CloneGraph] wrong origin %v, want %s", origin, sn.Path)
+	}
+
+	origin, err = fs.Origin()
+	if err != nil || origin != nil {
+		t.Errorf("[CloneGraph] not cloned fs has origin %v: %v", origin, err)
+	}
+
+	graph, err := ListCloneGraph(testPath)
+	if err != nil {
+		t.Fatal("[CloneGraph]", err)
+	}
+
+	dependents := graph.Dependents(sn.Path)
+	if len(dependents) != 1 || dependents[0].Path != clone.Path {
+		t.Errorf("[CloneGraph] wrong dependents: %v", dependents)
+	}
+
+	nested := CloneGraph{Clones: map[string][]Fs{
+		"tank/fs@s1":          {NewFs("tank/clone")},
+		"tank/clone/child@s2": {NewFs("tank/nested")},
+		"tank/clone-other@s3": {NewFs("tank/unrelated")},
+		"tank/nested@s4":      {NewFs("tank/deep")},
+	}}
+	dependents = nested.Dependents("tank/fs@s1")
+	paths := []string{}
+	for _, dependent := range dependents {
+		paths = append(paths, dependent.Path)
+	}
+	if fmt.Sprint(paths) != "[tank/clone tank/nested tank/deep]" {
+		t.Errorf("[CloneGraph] wrong dependents of children: %v", paths)
+	}
+}
+
+func TestPath(t *testing.T) {
+	path, err := JoinPath(testPath, "fs1", "fs2/fs3")
+	if err != nil || path != testPath+"/fs1/fs2/fs3" {
+		t.Errorf("[Path] wrong joined path %s: %v", path, err)
+	}
+
+	_, err = JoinPath(testPath, "fs1/")
+	if err == nil || !InvalidDataset.MatchString(err.Error()) {
+		t.Error("[Path] wrong error joining empty component:", err)
+	}
+
+	_, err = JoinPath(testPath, "fs1@s1")
+	if err == nil || !InvalidDataset.MatchString(err.Error()) {
+		t.Error("[Path] wrong error joining snapshot:", err)
+	}
+
+	for path, want := range map[string]string{
+		"tank/some/thing":    "tank/some",
+		"tank/some@snap/one": "tank/some",
+		"tank/some#mark":     "tank/some",
+		"tank":               "",
+	} {
+		if parent, _ := ParentPath(path); parent != want {
+			t.Errorf("[Path] wrong parent of %s: %s, want %s", path, parent, want)
+		}
+	}
+
+	if pool := PoolName("tank@snap"); pool != "tank" {
+		t.Errorf("[Path] wrong pool %s, want tank", pool)
+	}
+}
+
+func TestTree(t *testing.T) {
+	for _, f := range []string{"/fs1", "/fs2", "/fs2/fs3"} {
+		fs, err := CreateFs(testPath + f)
+		if err != nil {
+			t.Fatalf("[Tree] error creating fs '%s': %s", testPath+f, err)
+		}
+		defer fs.Destroy(RF_Hard)
+	}
+	NewFs(testPath + "/fs2").Snapshot("s1")
+
+	tree, err := LoadTree(testPath, "used")
+	if err != nil {
+		t.Fatal("[Tree]", err)
+	}
+
+	node, ok := tree.Find(testPath + "/fs2")
+	if !ok {
+		t.Fatal("[Tree] fs2 not found")
+	}
+	if node.Parent() != tree.Root {
+		t.Error("[Tree] wrong fs2 parent")
+	}
+	if len(node.Children()) != 1 || len(node.Snapshots()) != 1 {
+		t.Errorf("[Tree] wrong fs2 children %v or snapshots %v",
+			node.Children(), node.Snapshots())
+	}
+
+	paths := []string{}
+	tree.Root.WalkBreadthFirst(func(n *TreeNode) error {
+		paths = append(paths, n.getPath())
+		return nil
+	})
+	want := []string{
+		testPath, testPath + "/fs1", testPath + "/fs2", testPath + "/fs2/fs3",
+	}
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Errorf("[Tree] wrong walk order %v, want %v", paths, want)
+	}
+
+	children, err := NewFs(testPath).Children(false)
+	if err != nil || len(children) != 2 {
+		t.Errorf("[Tree] wrong children %v: %v", children, err)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	name, err := ParseName("tank/some/thing@snap")
+	if err != nil {
+		t.Fatal("[ParseName]", err)
+	}
+	if name.Pool != "tank" || len(name.Components) != 2 ||
+		name.Snapshot != "snap" || name.Dataset() != "tank/some/thing" {
+		t.Errorf("[ParseName] wrong parsed name: %+v", name)
+	}
+
+	name, err = ParseName("tank/fs#mark")
+	if err != nil || name.Bookmark != "mark" || name.String() != "tank/fs#mark" {
+		t.Errorf("[ParseName] wrong parsed bookmark %+v: %v", name, err)
+	}
+
+	for _, bad := range []string{
+		"", "/tank", badDataset, "tank//fs", "tank/fs@", "tank/a@b@c",
+		"tank/fs@a#b", "tank/f*s", "tank/..", "mirror1/fs", "log", "c0t0",
+		"1tank", "tank/" + string(bytes.Repeat([]byte("a"), 256)),
+	} {
+		_, err := ParseName(bad)
+		if err == nil {
+			t.Errorf("[ParseName] parsed invalid name '%s'", bad)
+			continue
+		}
+		if !InvalidDataset.MatchString(err.Error()) {
+			t.Errorf("[ParseName] wrong error for '%s': %s", bad, err)
+		}
+	}
+}
+
+func TestCreateFsWithOptions(t *testing.T) {
+	fs, err := CreateFsWithOptions(testPath+"/fs1/fs2", CreateOptions{
+		Props:   map[string]string{"quota": "1000000", "compression": "lz4"},
+		Parents: true,
+	})
+	if err != nil {
+		t.Fatal("[CreateFsWithOptions] error creating fs:", err)
+	}
+	defer NewFs(testPath + "/fs1").Destroy(RF_Hard)
+
+	compression, err := fs.GetProperty("compression")
+	if err != nil || compression != "lz4" {
+		t.Errorf("[CreateFsWithOptions] wrong compression %s: %v", compression, err)
+	}
+
+	_, err = CreateFsWithOptions(fs.Path, CreateOptions{Parents: true})
+	if err == nil || !AllreadyExists.MatchString(err.Error()) {
+		t.Error("[CreateFsWithOptions] wrong error creating dup fs:", err)
+	}
+
+	dry, err := CreateFsWithOptions(testPath+"/fs3", CreateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal("[CreateFsWithOptions] error in dry run:", err)
+	}
+	if ok, _ := dry.Exists(); ok {
+		dry.Destroy(RF_No)
+		t.Error("[CreateFsWithOptions] fs created in dry run")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	fs, err := CreateFsWithOptions(testPath+"/enc", CreateOptions{
+		Encryption: EncryptionOptions{
+			Algorithm:   "on",
+			KeyFormat:   "passphrase",
+			KeyLocation: "prompt",
+			Key:         strings.NewReader("secret passphrase"),
+		},
+	})
+	if err != nil {
+		t.Fatal("[Encryption] error creating encrypted fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	status, err := fs.KeyStatus()
+	if err != nil || status != KeyAvailable {
+		t.Errorf("[Encryption] wrong key status %s: %v", status, err)
+	}
+
+	if err := fs.Unmount(false); err != nil {
+		t.Fatal("[Encryption] error unmounting fs:", err)
+	}
+	if err := fs.UnloadKey(false); err != nil {
+		t.Fatal("[Encryption] error unloading key:", err)
+	}
+
+	err = fs.LoadKey(LoadKeyOptions{Key: strings.NewReader("wrong passphrase")})
+	if err == nil {
+		t.Error("[Encryption] loaded wrong key")
+	}
+
+	err = fs.LoadKey(LoadKeyOptions{Key: strings.NewReader("secret passphrase")})
+	if err != nil {
+		t.Error("[Encryption] error loading key:", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[Mount] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	if err := fs.Unmount(false); err != nil {
+		t.Fatal("[Mount] error unmounting fs:", err)
+	}
+	if ok, _ := fs.IsMounted(); ok {
+		t.Error("[Mount] fs mounted after unmount")
+	}
+
+	err = fs.Mount(MountOptions{Options: []string{"ro"}})
+	if err != nil {
+		t.Fatal("[Mount] error mounting fs:", err)
+	}
+
+	mountpoint, err := fs.Mountpoint()
+	if err != nil {
+		t.Fatal("[Mount] error getting mountpoint:", err)
+	}
+	if !mountpoint.Mounted || mountpoint.Path == "" || !mountpoint.AutoMount() {
+		t.Errorf("[Mount] wrong mountpoint: %+v", mountpoint)
+	}
+
+	err = mountError(fs.Path, parseError(
+		errors.New("exit status 1"),
+		[]byte("cannot unmount '/tank/test/fs1': target is busy\n"),
+	))
+	if err.(MountError).Reason != MountFailedBusy {
+		t.Error("[Mount] wrong mount error reason:", err)
+	}
+
+	err = mountError(fs.Path, parseError(EscalationError{Sudo, "denied"}, nil))
+	if !errors.As(err, &EscalationError{}) {
+		t.Error("[Mount] mount error doesn't unwrap cause:", err)
+	}
+}
+
+func TestShareOptions(t *testing.T) {
+	nfs := NFSShare{
+		ReadWrite:     []string{"@10.0.0.0/24", "backup"},
+		ReadOnlyHosts: []string{"@192.168.0.0/16"},
+		NoRootSquash:  true,
+	}
+	want := "rw=@10.0.0.0/24:backup,ro=@192.168.0.0/16,no_root_squash"
+	if nfs.String() != want {
+		t.Errorf("[ShareOptions] wrong sharenfs %s, want %s", nfs, want)
+	}
+
+	if share := (NFSShare{}).String(); share != "on" {
+		t.Errorf("[ShareOptions] wrong empty sharenfs %s, want on", share)
+	}
+
+	smb := SMBShare{Name: "data", GuestOK: true}
+	if smb.String() != "name=data,guestok=true" {
+		t.Errorf("[ShareOptions] wrong sharesmb %s", smb)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	output := "---- Permissions on tank/home/cindy -----------------------\n" +
+		"Local permissions:\n" +
+		"\tuser cindy create,destroy\n" +
+		"Descendent permissions:\n" +
+		"\tgroup staff mount\n" +
+		"---- Permissions on tank/home -----------------------------\n" +
+		"Permission sets:\n" +
+		"\t@pset snapshot,rollback\n" +
+		"Create time permissions:\n" +
+		"\tcreate,mount\n" +
+		"Local permissions:\n" +
+		"\tuser alice destroy\n" +
+		"Local+Descendent permissions:\n" +
+		"\tgroup staff @pset\n" +
+		"\teveryone send\n"
+
+	permissions, err := parsePermissions(output)
+	if err != nil {
+		t.Fatal("[Permissions] error parsing permissions:", err)
+	}
+	if len(permissions) != 2 || permissions[1].Path != "tank/home" {
+		t.Fatalf("[Permissions] wrong permissions: %+v", permissions)
+	}
+	if fmt.Sprint(permissions[1].Sets["@pset"]) != "[snapshot rollback]" ||
+		len(permissions[1].Create) != 2 {
+		t.Errorf("[Permissions] wrong sets or create perms: %+v", permissions[1])
+	}
+
+	path := "tank/home/cindy"
+	for _, check := range []struct {
+		user   string
+		groups []string
+		perm   string
+		want   bool
+	}{
+		{"cindy", nil, "create", true},
+		{"cindy", nil, "send", true},
+		{"cindy", nil, "mount", false},
+		{"bob", []string{"staff"}, "rollback", true},
+		{"alice", nil, "destroy", false},
+	} {
+		got := hasPermission(permissions, path, check.user, check.groups, check.perm)
+		if got != check.want {
+			t.Errorf("[Permissions] %s %s: got %v, want %v",
+				check.user, check.perm, got, check.want)
+		}
+	}
+
+	args := Permission{
+		Users: []string{"cindy"}, Everyone: true, Local: true,
+		Perms: []string{"mount"}, Sets: []string{"pset"},
+	}.args("allow")
+	want := "[[allow -l -u cindy mount,@pset] [allow -l -e mount,@pset]]"
+	if fmt.Sprint(args) != want {
+		t.Errorf("[Permissions] wrong allow args %v, want %s", args, want)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for size, want := range map[string]int64{
+		"1024":                1024,
+		"10G":                 10 << 30,
+		"1.5T":                3 << 39,
+		"512KB":               512 << 10,
+		"2MiB":                2 << 20,
+		"none":                0,
+		"1.5":                 1,
+		"100B":                100,
+		"9223372036854775807": math.MaxInt64,
+	} {
+		got, err := ParseSize(size)
+		if err != nil || got != want {
+			t.Errorf("[ParseSize] %s parsed as %d (%v), want %d",
+				size, got, err, want)
+		}
+	}
+
+	for _, size := range []string{
+		"", "G", "ten", "-1", "10X", "8E", "nan", "inf", "1e3", "1.", ".5",
+		"1KK", "9223372036854775808",
+	} {
+		if _, err := ParseSize(size); err == nil {
+			t.Errorf("[ParseSize] parsed invalid size '%s'", size)
+		}
+	}
+
+	fs := NewZfs(fakeRunner{stdout: "1.50\n"}, false).NewFs("tank/fs")
+	if value, err := fs.GetPropertyInt("compressratio"); err == nil {
+		t.Errorf("[ParseSize] ratio parsed as int %d", value)
+	}
+	fs = NewZfs(fakeRunner{stdout: "none\n"}, false).NewFs("tank/fs")
+	if value, err := fs.GetPropertyInt("quota"); err != nil || value != 0 {
+		t.Errorf("[ParseSize] none parsed as %d: %v", value, err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[Quota] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	if err := fs.SetProperty("quota", "10G"); err != nil {
+		t.Error("[Quota] error setting quota as size string:", err)
+	}
+
+	quota, err := fs.Quota()
+	if err != nil || quota != 10<<30 {
+		t.Errorf("[Quota] wrong quota %d: %v", quota, err)
+	}
+
+	if err := fs.SetQuota(0); err != nil {
+		t.Error("[Quota] error removing quota:", err)
+	}
+
+	space, err := fs.SpaceBreakdown()
+	if err != nil {
+		t.Fatal("[Quota] error getting space breakdown:", err)
+	}
+	if space.Used == 0 || space.Available == 0 {
+		t.Errorf("[Quota] wrong space breakdown: %+v", space)
+	}
+}
+
+func TestSpaceUsage(t *testing.T) {
+	output := "POSIX User\troot\t1536\tnone\t3\tnone\n" +
+		"POSIX User\t1001\t1073741824\t10737418240\t120\t1000\n"
+
+	usages, err := parseSpaceUsage(output, true)
+	if err != nil {
+		t.Fatal("[SpaceUsage] error parsing userspace:", err)
+	}
+
+	want := []SpaceUsage{
+		{"POSIX User", "root", 1536, 0, 3, 0},
+		{"POSIX User", "1001", 1 << 30, 10 << 30, 120, 1000},
+	}
+	if fmt.Sprint(usages) != fmt.Sprint(want) {
+		t.Errorf("[SpaceUsage] wrong usages %v, want %v", usages, want)
+	}
+
+	usages, err = parseSpaceUsage("42\t512\tnone\t-\tnone\n", false)
+	if err != nil || len(usages) != 1 || usages[0].Type != "Project" {
+		t.Errorf("[SpaceUsage] wrong project usage %v: %v", usages, err)
+	}
+
+	args := SpaceOptions{NumericIDs: true, Types: []string{"posixuser"}}.
+		args("userspace")
+	if args[len(args)-1] != "posixuser" || args[len(args)-3] != "-n" {
+		t.Errorf("[SpaceUsage] wrong userspace args: %v", args)
+	}
+
+	args = SpaceOptions{NumericIDs: true}.args("projectspace")
+	if fmt.Sprint(args) != "[projectspace -Hp -o name,used,quota,objused,objquota]" {
+		t.Errorf("[SpaceUsage] wrong projectspace args: %v", args)
+	}
+	_, err = NewFs("tank/fs").ProjectSpace(SpaceOptions{Types: []string{"all"}})
+	if err == nil {
+		t.Error("[SpaceUsage] projectspace accepted types")
+	}
+}
+
+type recordingObserver struct {
+	mutex   sync.Mutex
+	before  []CommandEvent
+	after   []CommandEvent
+	retries []RetryEvent
+}
+
+func (o *recordingObserver) BeforeCommand(event CommandEvent) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.before = append(o.before, event)
+}
+
+func (o *recordingObserver) AfterCommand(event CommandEvent) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.after = append(o.after, event)
+}
+
+// Returns copies of recorded command events
+func (o *recordingObserver) events() ([]CommandEvent, []CommandEvent) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]CommandEvent{}, o.before...),
+		append([]CommandEvent{}, o.after...)
+}
+
+// Runner returning canned output without executing anything
+type fakeRunner struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+func (r fakeRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	return fakeWorker{runner: r}
+}
+
+type fakeWorker struct {
+	runcmd.CmdWorker
+	runner fakeRunner
+}
+
+func (w fakeWorker) Output() ([]byte, []byte, error) {
+	return []byte(w.runner.stdout), []byte(w.runner.stderr), w.runner.err
+}
+
+func (w fakeWorker) StdoutPipe() (io.Reader, error) {
+	return strings.NewReader(w.runner.stdout), nil
+}
+
+func (w fakeWorker) StderrPipe() (io.Reader, error) {
+	return strings.NewReader(w.runner.stderr), nil
+}
+
+func (w fakeWorker) Start() error {
+	return nil
+}
+
+func (w fakeWorker) Wait() error {
+	return w.runner.err
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	z := NewZfs(fakeRunner{
+		stderr: strings.Repeat("e", 2000),
+		err:    errors.New("exit status 2"),
+	}, true)
+	z.SetObserver(observer)
+
+	z.Command("zfs", "set", "org:api_token=hunter2", "tank/fs").Output()
+	z.Command("zfs", "set", "sharenfs=rw=@10.0.0.0/8", "tank/fs").Output()
+
+	if len(observer.before) != 2 || len(observer.after) != 2 {
+		t.Fatalf("[Observer] wrong events: %+v, %+v",
+			observer.before, observer.after)
+	}
+
+	event := observer.after[0]
+	want := "[sudo -n zfs set org:api_token=*** tank/fs]"
+	if fmt.Sprint(event.Args) != want {
+		t.Errorf("[Observer] wrong args %v, want %s", event.Args, want)
+	}
+	if event.ExitStatus != 2 || len(event.Stderr) != maxObservedStderr {
+		t.Errorf("[Observer] wrong exit status %d or stderr length %d",
+			event.ExitStatus, len(event.Stderr))
+	}
+	if event.Runner != RunnerLocal {
+		t.Errorf("[Observer] wrong runner kind %s", event.Runner)
+	}
+
+	want = "[sudo -n zfs set sharenfs=*** tank/fs]"
+	if got := fmt.Sprint(observer.after[1].Args); got != want {
+		t.Errorf("[Observer] wrong args %s, want %s", got, want)
+	}
+
+	if kind := runnerKind(&runcmd.Remote{}); kind != RunnerRemote {
+		t.Errorf("[Observer] wrong remote runner kind %s", kind)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	z, plan := NewZfs(fakeRunner{
+		stdout: "destroy\ttank/fs@a\ndestroy\ttank/fs@b\nreclaim\t4096\n",
+	}, false).DryRun()
+
+	fs := z.NewFs("tank/fs")
+	snap, err := z.NewSnapshot("tank/fs@a")
+	if err != nil {
+		t.Fatal("[DryRun] error creating snapshot wrapper:", err)
+	}
+
+	if err := snap.Rollback(RF_Soft); err != nil {
+		t.Error("[DryRun] error planning rollback:", err)
+	}
+	if err := snap.Fs.Destroy(RF_Soft); err != nil {
+		t.Error("[DryRun] error planning destroy:", err)
+	}
+	if err := fs.SetProperty("quota", "1G"); err != nil {
+		t.Error("[DryRun] error planning set:", err)
+	}
+	if _, err := fs.Rename("tank/renamed"); err != nil {
+		t.Error("[DryRun] error planning rename:", err)
+	}
+	if _, err := fs.Rename("other/renamed"); err != RenamePoolError {
+		t.Error("[DryRun] renamed fs to another pool:", err)
+	}
+
+	want := "zfs rollback -r tank/fs@a\n" +
+		"zfs destroy -vp -r tank/fs\n" +
+		"zfs set quota=1G tank/fs\n" +
+		"zfs rename -p tank/fs tank/renamed"
+	if plan.String() != want {
+		t.Errorf("[DryRun] wrong plan:\n%s\nwant:\n%s", plan, want)
+	}
+
+	destroyed := plan.Destroyed()
+	if fmt.Sprint(destroyed) != "[tank/fs@a tank/fs@b]" ||
+		plan.Reclaimed() != 4096 {
+		t.Errorf("[DryRun] wrong destroy report %v, %d",
+			destroyed, plan.Reclaimed())
+	}
+
+	if _, err := fs.GetProperty("quota"); err != nil {
+		t.Error("[DryRun] read only command not run:", err)
+	}
+	if len(plan.Commands()) != 4 {
+		t.Errorf("[DryRun] read only command planned: %v", plan.Commands())
+	}
+
+	runner := scriptedRunner{outputs: map[string]string{
+		"zfs list -H -o name tank/fs@a": "tank/fs@a\n",
+		"zfs send -nvP tank/fs@a":       "full\ttank/fs@a\t1024\nsize\t1024\n",
+	}, calls: map[string]int{}}
+	z, plan = NewZfs(runner, false).DryRun()
+	snap, _ = z.NewSnapshot("tank/fs@a")
+
+	stream := &bytes.Buffer{}
+	if err := snap.SendStream(stream); err != nil {
+		t.Error("[DryRun] error planning send:", err)
+	}
+	if stream.Len() != 0 || plan.String() != "zfs send tank/fs@a" ||
+		plan.Sent() != 1024 {
+		t.Errorf("[DryRun] wrong send plan %q, sent %d, written %d",
+			plan, plan.Sent(), stream.Len())
+	}
+	if runner.calls["zfs send tank/fs@a"] != 0 {
+		t.Error("[DryRun] send run in dry-run mode")
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		loaded <- z.NewFs("tank/fs").LoadKey(LoadKeyOptions{
+			Key: strings.NewReader("secret"),
+		})
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Error("[DryRun] error planning load-key:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("[DryRun] load-key with key hangs")
+	}
+	if !strings.HasSuffix(plan.String(), "zfs load-key tank/fs") {
+		t.Errorf("[DryRun] load-key not planned:\n%s", plan)
+	}
+}
+
+func TestDestroyWithOptions(t *testing.T) {
+	plan, err := parseDestroyPlan("destroy\ttank/fs@a\n" +
+		"destroy\ttank/fs#b\ndestroy\ttank/fs\nreclaim\t8192\n")
+	if err != nil {
+		t.Fatal("[DestroyWithOptions] error parsing plan:", err)
+	}
+	if fmt.Sprint(plan.Datasets()) != "[tank/fs tank/fs@a tank/fs#b]" ||
+		plan.Reclaimed != 8192 {
+		t.Errorf("[DestroyWithOptions] wrong plan %+v", plan)
+	}
+
+	args := DestroyOptions{Recursive: RF_Hard, Defer: true, Force: true}.args()
+	if fmt.Sprint(args) != "[destroy -vp -R -d -f]" {
+		t.Errorf("[DestroyWithOptions] wrong args %v", args)
+	}
+
+	fs, err := CreateFs(testPath + "/fs1")
+	if err != nil {
+		t.Fatal("[DestroyWithOptions] error creating fs:", err)
+	}
+	defer fs.Destroy(RF_Hard)
+
+	for _, name := range []string{"s1", "s2", "s3"} {
+		if _, err := fs.Snapshot(name); err != nil {
+			t.Fatal("[DestroyWithOptions] error creating snapshot:", err)
+		}
+	}
+
+	sn, _ := NewSnapshot(fs.Path + "@s1")
+	clone, err := sn.Clone(testPath + "/cln1")
+	if err != nil {
+		t.Fatal("[DestroyWithOptions] error creating clone:", err)
+	}
+	defer clone.Destroy(RF_Hard)
+
+	plan, err = fs.DestroyWithOptions(
+		DestroyOptions{Recursive: RF_Hard, DryRun: true},
+	)
+	if err != nil {
+		t.Fatal("[DestroyWithOptions] error planning destroy:", err)
+	}
+	if len(plan.Clones) != 1 || plan.Clones[0] != clone.Path {
+		t.Errorf("[DestroyWithOptions] wrong clones in plan: %+v", plan)
+	}
+	if exists, _ := clone.Exists(); !exists {
+		t.Error("[DestroyWithOptions] clone destroyed in dry-run")
+	}
+
+	plan, err = fs.DestroySnapshotRange("s2", "s3", DestroyOptions{})
+	if err != nil {
+		t.Fatal("[DestroyWithOptions] error destroying range:", err)
+	}
+	if len(plan.Snapshots) != 2 {
+		t.Errorf("[DestroyWithOptions] wrong destroyed range: %+v", plan)
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	z := NewZfs(fakeRunner{
+		stderr: "sudo: a password is required\n",
+		err:    errors.New("exit status 1"),
+	}, true)
+
+	_, err := z.NewFs("tank/fs").GetProperty("quota")
+	if _, ok := err.(EscalationError); !ok || !NeedSudo.MatchString(err.Error()) {
+		t.Errorf("[Escalation] wrong error %#v", err)
+	}
+
+	worker := z.Command("zfs", "send", "tank/fs@s1")
+	if err := worker.Start(); err != nil {
+		t.Fatal("[Escalation] error starting command:", err)
+	}
+	if _, ok := worker.Wait().(EscalationError); !ok {
+		t.Error("[Escalation] wait error of started command not mapped")
+	}
+
+	z.SetEscalation(NoEscalation)
+	_, err = z.NewFs("tank/fs").GetProperty("quota")
+	if _, ok := err.(EscalationError); ok {
+		t.Error("[Escalation] escalation error without escalation:", err)
+	}
+
+	for escalation, want := range map[*Escalation]string{
+		&Doas:   "[doas -n zfs list]",
+		&Pfexec: "[pfexec zfs list]",
+	} {
+		name, args := escalation.wrap("zfs", []string{"list"})
+		if got := fmt.Sprint(append([]string{name}, args...)); got != want {
+			t.Errorf("[Escalation] wrong command %s, want %s", got, want)
+		}
+	}
+
+	custom := CustomEscalation("sudo", "-u", "zfsadmin")
+	name, args := custom.wrap("zfs", []string{"list"})
+	if name != "sudo" || fmt.Sprint(args) != "[-u zfsadmin zfs list]" {
+		t.Errorf("[Escalation] wrong custom command %s %v", name, args)
+	}
+
+	runner := scriptedRunner{outputs: map[string]string{
+		"sudo -n id -un": "root\n",
+		"sudo -n id -Gn": "root\n",
+	}, calls: map[string]int{}}
+	allowed, err := NewZfs(runner, true).NewFs("tank/fs").CanPerform("destroy")
+	if err != nil || !allowed {
+		t.Errorf("[Escalation] escalated user not allowed: %v, %v", allowed, err)
+	}
+}
+
+// Runner which commands hang until they are killed
+type hangingRunner struct {
+	killed chan struct{}
+}
+
+func (r hangingRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	return hangingWorker{runner: r}
+}
+
+type hangingWorker struct {
+	runcmd.CmdWorker
+	runner hangingRunner
+}
+
+func (w hangingWorker) Output() ([]byte, []byte, error) {
+	<-w.runner.killed
+	return nil, nil, errors.New("signal: killed")
+}
+
+func (w hangingWorker) Kill() error {
+	close(w.runner.killed)
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	if _, err := New(WithTimeout(0)); err == nil {
+		t.Error("[Client] created client with zero timeout")
+	}
+
+	observer := &recordingObserver{}
+	runner := hangingRunner{make(chan struct{})}
+	z, err := New(
+		WithRunner(runner),
+		WithEscalation(Sudo),
+		WithObserver(observer),
+		WithTimeout(50*time.Millisecond),
+		WithOutputFormat(OutputText),
+	)
+	if err != nil {
+		t.Fatal("[Client] error creating client:", err)
+	}
+
+	snap, err := z.NewSnapshot("tank/fs@s1")
+	if err != nil {
+		t.Fatal("[Client] error creating snapshot wrapper:", err)
+	}
+	if snap.runner.ZfsRunner != z.ZfsRunner ||
+		snap.Fs.runner.ZfsRunner != z.ZfsRunner {
+		t.Error("[Client] snapshot doesn't use client runner")
+	}
+
+	_, err = snap.GetProperty("guid")
+	if _, ok := err.(TimeoutError); !ok {
+		t.Errorf("[Client] wrong timeout error %#v", err)
+	}
+
+	select {
+	case <-runner.killed:
+	case <-time.After(time.Second):
+		t.Error("[Client] timed out command not killed")
+	}
+
+	before, after := observer.events()
+	want := "[sudo -n zfs get -Hp -o value guid tank/fs@s1]"
+	if len(before) != 1 || fmt.Sprint(before[0].Args) != want {
+		t.Errorf("[Client] wrong commands %+v, want %s", before, want)
+	}
+	if len(after) != 1 {
+		t.Fatalf("[Client] wrong finished commands %+v", after)
+	}
+	if _, ok := after[0].Err.(TimeoutError); !ok {
+		t.Errorf("[Client] observer got wrong error %#v", after[0].Err)
+	}
+
+	failed := NewZfs(failedRunner{errors.New("no runner")}, false)
+	err = failed.NewFs("tank/fs").LoadKey(LoadKeyOptions{
+		Key: strings.NewReader("secret"),
+	})
+	if err == nil || err.Error() != "no runner" {
+		t.Error("[Client] wrong error of failed runner:", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	version, err := parseVersion("zfs-2.1.5-1ubuntu6~22.04.1\n" +
+		"zfs-kmod-2.1.4-1ubuntu1\n")
+	if err != nil {
+		t.Fatal("[Version] error parsing version:", err)
+	}
+	if version.String() != "2.1.5" || version.Kernel != "zfs-kmod-2.1.4-1ubuntu1" {
+		t.Errorf("[Version] wrong version %+v", version)
+	}
+	if !version.AtLeast(2, 1, 0) || version.AtLeast(2, 2, 0) {
+		t.Errorf("[Version] wrong version comparison for %s", version)
+	}
+
+	if _, err := parseVersion("unrecognized command 'version'"); err == nil {
+		t.Error("[Version] parsed invalid version")
+	}
+
+	observer := &recordingObserver{}
+	z, err := New(
+		WithRunner(fakeRunner{stdout: "zfs-2.2.0-1\n"}),
+		WithEscalation(Sudo),
+		WithObserver(observer),
+		WithZfsBinary("/usr/local/sbin/zfs"),
+		WithPlainOutput(),
+	)
+	if err != nil {
+		t.Fatal("[Version] error creating client:", err)
+	}
+
+	version, err = z.Version()
+	if err != nil || version.Minor != 2 {
+		t.Errorf("[Version] wrong version %+v: %v", version, err)
+	}
+
+	want := "[env -u ZFS_COLOR LC_ALL=C sudo -n /usr/local/sbin/zfs version]"
+	if len(observer.before) != 1 ||
+		fmt.Sprint(observer.before[0].Args) != want {
+		t.Errorf("[Version] wrong commands %+v, want %s", observer.before, want)
+	}
+
+	if _, err := New(WithEnv(map[string]string{"BAD NAME": "1"})); err == nil {
+		t.Error("[Version] created client with invalid env")
+	}
+}
+
+// Runner returning output recorded for exact command line
+type scriptedRunner struct {
+	outputs map[string]string
+	calls   map[string]int
+}
+
+func (r scriptedRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	line := strings.Join(append([]string{name}, args...), " ")
+	r.calls[line]++
+
+	output, ok := r.outputs[line]
+	if !ok {
+		return fakeWorker{runner: fakeRunner{
+			stderr: "unexpected command " + line,
+			err:    errors.New("exit status 2"),
+		}}
+	}
+
+	return fakeWorker{runner: fakeRunner{stdout: output}}
+}
+
+func TestCapabilities(t *testing.T) {
+	runner := scriptedRunner{
+		outputs: map[string]string{
+			"zfs version": "zfs-0.8.3-1ubuntu12\nzfs-kmod-0.8.3-1ubuntu12\n",
+			"zpool get -H -o name,property,value all": "" +
+				"tank\tsize\t1T\n" +
+				"tank\tfeature@encryption\tenabled\n" +
+				"tank\tfeature@redaction_bookmarks\tdisabled\n",
+		},
+		calls: map[string]int{},
+	}
+	z := NewZfs(runner, false)
+
+	for i := 0; i < 2; i++ {
+		capabilities, err := z.Capabilities()
+		if err != nil {
+			t.Fatal("[Capabilities] error detecting capabilities:", err)
+		}
+
+		if !capabilities.Supports(CapRawSend, "") ||
+			!capabilities.Supports(CapEncryption, "tank") {
+			t.Errorf("[Capabilities] missing capabilities %+v", capabilities)
+		}
+		if capabilities.Supports(CapJSON, "") {
+			t.Error("[Capabilities] JSON supported on 0.8")
+		}
+	}
+
+	if runner.calls["zfs version"] != 1 {
+		t.Errorf("[Capabilities] capabilities not cached: %v", runner.calls)
+	}
+
+	err := Capabilities{Version: ZfsVersion{Userland: "zfs-2.1.0", Major: 2, Minor: 1}}.
+		Check(CapRedact, "tank")
+	if err == nil || !strings.Contains(err.Error(), "unsupported on this host") {
+		t.Error("[Capabilities] wrong error for missing pool feature:", err)
+	}
+
+	legacy := Capabilities{}
+	if !legacy.Supports(CapCompressedSend, "") || legacy.Supports(CapRawSend, "") {
+		t.Error("[Capabilities] wrong capabilities of zfs without version")
+	}
+
+	snap, _ := z.NewSnapshot("tank/fs@s1")
+	err = snap.SendStreamWithOptions(nil, io.Discard, SendOptions{Raw: true})
+	if err == nil || strings.Contains(err.Error(), "unsupported") {
+		t.Error("[Capabilities] raw send rejected on 0.8:", err)
+	}
+
+	_, err = z.CreateFsWithOptions("backup/fs", CreateOptions{
+		Encryption: EncryptionOptions{KeyFormat: "passphrase"},
+	})
+	if _, ok := err.(UnsupportedError); !ok {
+		t.Error("[Capabilities] encryption by key format not checked:", err)
+	}
+}
+
+func readFixture(t *testing.T, name string) string {
+	data, err := os.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal("error reading fixture:", err)
+	}
+	return string(data)
+}
+
+func TestJSONOutput(t *testing.T) {
+	listArgs := "-r -t filesystem,snapshot,volume -o name,type,used,mountpoint tank"
+	getArgs := "used,compression,quota tank/fs"
+	poolArgs := "-o name,health,size,capacity"
+	snapshotArgs := "-r -t snapshot -o name,type tank/fs"
+
+	text := scriptedRunner{outputs: map[string]string{
+		"zfs version": "zfs-2.2.4-1\nzfs-kmod-2.2.4-1\n",
+		"zpool get -H -o name,property,value all":         "",
+		"zfs list -Hp " + listArgs:                        readFixture(t, "list.txt"),
+		"zfs get -Hp -o property,value,source " + getArgs: readFixture(t, "get.txt"),
+		"zfs get -Hp -o value quota tank/fs":              "10737418240\n",
+		"zpool list -Hp " + poolArgs:                      readFixture(t, "zpool_list.txt"),
+		"zfs list -Hr -o name -t snapshot tank/fs":        readFixture(t, "list_snapshots.txt"),
+		"zfs list -Hr -o name tank":                       readFixture(t, "list_fs.txt"),
+		"zpool status -p tank":                            readFixture(t, "zpool_status.txt"),
+	}, calls: map[string]int{}}
+
+	json := scriptedRunner{outputs: map[string]string{
+		"zfs version": "zfs-2.3.0-1\nzfs-kmod-2.3.0-1\n",
+		"zpool get -H -o name,property,value all": "",
+		"zfs list -jp " + listArgs:                readFixture(t, "list.json"),
+		"zfs get -jp " + getArgs:                  readFixture(t, "get.json"),
+		"zfs get -jp quota tank/fs":               readFixture(t, "get.json"),
+		"zpool list -jp " + poolArgs:              readFixture(t, "zpool_list.json"),
+		"zfs list -jp " + snapshotArgs:            readFixture(t, "list_snapshots.json"),
+		"zfs list -jp -r -o name,type tank":       readFixture(t, "list_fs.json"),
+		"zpool status -jp tank":                   readFixture(t, "zpool_status.json"),
+	}, calls: map[string]int{}}
+
+	results := []string{}
+	for _, runner := range []scriptedRunner{text, json} {
+		z := NewZfs(runner, false)
+		result := ""
+
+		datasets, err := z.List(ListOptions{
+			Paths:      []string{"tank"},
+			Recursive:  true,
+			Types:      []DatasetType{TypeFilesystem, TypeSnapshot, TypeVolume},
+			Properties: []string{"used", "mountpoint"},
+		})
+		if err != nil {
+			t.Fatal("[JSONOutput] error listing datasets:", err)
+		}
+		for _, dataset := range datasets {
+			result += fmt.Sprintf("%s %T %s %v\n", dataset.getPath(),
+				dataset.ZfsEntry, dataset.Type, dataset.Properties)
+		}
+
+		fs := z.NewFs("tank/fs")
+		properties, err := fs.GetProperties("used", "compression", "quota")
+		if err != nil {
+			t.Fatal("[JSONOutput] error getting properties:", err)
+		}
+		result += fmt.Sprint(properties, "\n")
+
+		quota, err := fs.GetPropertyInt("quota")
+		if err != nil {
+			t.Fatal("[JSONOutput] error getting property:", err)
+		}
+		result += fmt.Sprint(quota, "\n")
+
+		snapshots, err := fs.ListSnapshots()
+		if err != nil {
+			t.Fatal("[JSONOutput] error listing snapshots:", err)
+		}
+		for _, snapshot := range snapshots {
+			result += snapshot.Path + " " + snapshot.Fs.Path + " " +
+				snapshot.Name + "\n"
+		}
+
+		pools, err := z.ListPools("size", "capacity")
+		if err != nil {
+			t.Fatal("[JSONOutput] error listing pools:", err)
+		}
+		result += fmt.Sprint(pools, "\n")
+
+		filesystems, err := z.ListFs("tank")
+		if err != nil {
+			t.Fatal("[JSONOutput] error listing filesystems:", err)
+		}
+		for _, fs := range filesystems {
+			result += fs.Path + "\n"
+		}
+
+		status, err := z.GetPoolStatus("tank")
+		if err != nil {
+			t.Fatal("[JSONOutput] error getting pool status:", err)
+		}
+		result += fmt.Sprintf("%+v\n", status)
+
+		results = append(results, result)
+	}
+
+	if results[0] != results[1] {
+		t.Errorf("[JSONOutput] text and json results differ:\n%s\n\n%s",
+			results[0], results[1])
+	}
+
+	if json.calls["zfs list -jp "+listArgs] != 1 {
+		t.Errorf("[JSONOutput] json output not used: %v", json.calls)
+	}
+	if !strings.Contains(results[1], "tank/fs@s2 tank/fs s2\ntank/fs@s1") {
+		t.Errorf("[JSONOutput] json order not kept:\n%s", results[1])
+	}
+	if !strings.Contains(results[1], "tank\ntank/fs\ntank/fs/child\ntank/vol\n") {
+		t.Errorf("[JSONOutput] wrong filesystems:\n%s", results[1])
+	}
+
+	want := "{Name:tank State:DEGRADED Root:{Name:tank State:DEGRADED " +
+		"Read:0 Write:0 Checksum:0 Vdevs:[" +
+		"{Name:mirror-0 State:DEGRADED Read:0 Write:0 Checksum:0 Vdevs:[" +
+		"{Name:sda State:ONLINE Read:0 Write:0 Checksum:0 Vdevs:[]} " +
+		"{Name:sdb State:UNAVAIL Read:0 Write:0 Checksum:0 Vdevs:[]}]} " +
+		"{Name:mirror-1 State:ONLINE Read:0 Write:0 Checksum:0 Vdevs:[" +
+		"{Name:sdc State:ONLINE Read:0 Write:0 Checksum:2 Vdevs:[]} " +
+		"{Name:sdd State:ONLINE Read:0 Write:0 Checksum:0 Vdevs:[]}]}]} " +
+		"ErrorCount:3}"
+	if !strings.Contains(results[1], want) {
+		t.Errorf("[JSONOutput] wrong pool status:\n%s", results[1])
+	}
+
+	// json depends only on zfs version, failed detection is not repeated
+	delete(json.outputs, "zpool get -H -o name,property,value all")
+	z := NewZfs(json, false)
+	if _, err := z.ListPools("size", "capacity"); err != nil {
+		t.Error("[JSONOutput] json not used without pool features:", err)
+	}
+	if json.calls["zpool get -H -o name,property,value all"] != 0 {
+		t.Errorf("[JSONOutput] pool features read for json: %v", json.calls)
+	}
+
+	delete(json.outputs, "zfs version")
+	versionCalls := json.calls["zfs version"]
+	z = NewZfs(json, false)
+	for i := 0; i < 2; i++ {
+		if _, err := z.ListPools("size", "capacity"); err == nil {
+			t.Error("[JSONOutput] json used after failed detection")
+		}
+	}
+	if json.calls["zfs version"] != versionCalls+1 {
+		t.Errorf("[JSONOutput] failed detection repeated: %v", json.calls)
+	}
+}
+
+// Runner failing first commands with given stderr
+type flakyRunner struct {
+	failures *int
+	stderr   string
+
+	// Stderr of attempts after failures, successful if empty
+	after string
+}
+
+func (r flakyRunner) Command(name string, args ...string) runcmd.CmdWorker {
+	if *r.failures > 0 {
+		*r.failures--
+		return fakeWorker{runner: fakeRunner{
+			stderr: r.stderr, err: errors.New("exit status 1"),
+		}}
+	}
+
+	if r.after != "" {
+		return fakeWorker{runner: fakeRunner{
+			stderr: r.after, err: errors.New("exit status 1"),
+		}}
+	}
+
+	return fakeWorker{runner: fakeRunner{}}
+}
+
+func (o *recordingObserver) RetryCommand(event RetryEvent) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.retries = append(o.retries, event)
+}
+
+func TestRetry(t *testing.T) {
+	delays := []time.Duration{}
+	policy := DefaultRetryPolicy()
+	policy.Sleep = func(delay time.Duration) {
+		delays = append(delays, delay)
+	}
+
+	failures := 2
+	observer := &recordingObserver{}
+	z, err := New(
+		WithRunner(flakyRunner{failures: &failures, stderr: "cannot destroy 'tank/fs': dataset is busy\n"}),
+		WithObserver(observer),
+		WithRetry(policy),
+	)
+	if err != nil {
+		t.Fatal("[Retry] error creating client:", err)
+	}
+
+	if err := z.NewFs("tank/fs").Destroy(RF_No); err != nil {
+		t.Error("[Retry] busy destroy not retried:", err)
+	}
+
+	if len(observer.retries) != 2 || observer.retries[1].Attempt != 3 {
+		t.Errorf("[Retry] wrong retries reported: %+v", observer.retries)
+	}
+	if len(delays) != 2 || delays[0] > 500*time.Millisecond ||
+		delays[0] < 400*time.Millisecond || delays[1] < 800*time.Millisecond {
+		t.Errorf("[Retry] wrong delays %v", delays)
+	}
+
+	failures = 1
+	if _, err := z.NewFs("tank/fs").Snapshot("s1"); err == nil {
+		t.Error("[Retry] not idempotent snapshot retried")
+	}
+
+	failures = 5
+	err = z.NewFs("tank/fs").Destroy(RF_No)
+	if err == nil || !DatasetBusy.MatchString(err.Error()) {
+		t.Error("[Retry] wrong error after all attempts:", err)
+	}
+	if failures != 2 {
+		t.Errorf("[Retry] wrong number of attempts: %d", 5-failures)
+	}
+
+	if _, err := New(WithRetry(RetryPolicy{})); err == nil {
+		t.Error("[Retry] created client with zero attempts")
+	}
+
+	failures = 1
+	z, err = New(
+		WithRunner(flakyRunner{
+			failures: &failures,
+			stderr:   "cannot unmount '/tank/fs': pool or dataset is busy\n",
+			after:    "cannot open 'tank/fs': dataset does not exist\n",
+		}),
+		WithRetry(policy),
+	)
+	if err != nil {
+		t.Fatal("[Retry] error creating client:", err)
+	}
+
+	if err := z.NewFs("tank/fs").Destroy(RF_No); err != nil {
+		t.Error("[Retry] destroy of removed dataset failed on retry:", err)
+	}
+	if _, err := z.NewFs("tank/fs").GetProperty("quota"); err == nil {
+		t.Error("[Retry] missing dataset treated as success for get")
+	}
+}
+
+func TestStdSettings(t *testing.T) {
+	previous := std()
+	defer SetStd(previous)
+
+	z, err := New(
+		WithRunner(fakeRunner{stdout: "0\n"}),
+		WithOutputFormat(OutputText),
+	)
+	if err != nil {
+		t.Fatal("[StdSettings] error creating client:", err)
+	}
+	SetStd(z)
+
+	observer := &recordingObserver{}
+	SetStdObserver(observer)
+	fs := NewFs("tank/fs")
+
+	SetStdSudo(true)
+	fs.GetProperty("quota")
+
+	SetStdSudo(false)
+	fs.GetProperty("quota")
+
+	if len(observer.before) != 2 ||
+		observer.before[0].Args[0] != "sudo" ||
+		observer.before[1].Args[0] != "zfs" {
+		t.Errorf("[StdSettings] existing fs doesn't see std settings: %+v",
+			observer.before)
+	}
+}
+
+func TestDestroySnapshotRange(t *testing.T) {
+	observer := &recordingObserver{}
+	z := NewZfs(fakeRunner{stdout: "destroy\ttank/fs@s1\nreclaim\t4096\n"}, false)
+	z.SetObserver(observer)
+	fs := z.NewFs("tank/fs")
+
+	if _, err := fs.DestroySnapshotRange("", "", DestroyOptions{OpenRange: true}); err == nil {
+		t.Error("[DestroySnapshotRange] destroyed range without bounds")
+	}
+	if _, err := fs.DestroySnapshotRange("", "s1", DestroyOptions{}); err == nil {
+		t.Error("[DestroySnapshotRange] destroyed open range without OpenRange")
+	}
+	if len(observer.before) != 0 {
+		t.Errorf("[DestroySnapshotRange] commands run: %+v", observer.before)
+	}
+
+	plan, err := fs.DestroySnapshotRange("", "s1", DestroyOptions{OpenRange: true})
+	if err != nil || len(plan.Snapshots) != 1 {
+		t.Errorf("[DestroySnapshotRange] wrong plan %+v: %v", plan, err)
+	}
+	if len(observer.before) != 1 ||
+		fmt.Sprint(observer.before[0].Args) != "[zfs destroy -vp tank/fs@%s1]" {
+		t.Errorf("[DestroySnapshotRange] wrong commands %+v", observer.before)
+	}
+}